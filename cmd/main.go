@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/robfig/cron/v3"
@@ -28,6 +30,23 @@ func init() {
 }
 
 func main() {
+	args := os.Args[1:]
+	if len(args) > 0 && strings.HasPrefix(args[0], "--target=") {
+		// Selects the BackupTarget restore, verify and snapshots read from
+		err := docker.UseTarget(strings.TrimPrefix(args[0], "--target="))
+		if err != nil {
+			log.Fatal(err)
+		}
+		args = args[1:]
+		if len(args) == 0 {
+			log.Fatal("Usage: DockerRight --target=<name> <command> [args...]")
+		}
+	}
+	if len(args) > 0 {
+		runCommand(args[0], args[1:])
+		return
+	}
+
 	log.Info("Starting DockerRight")
 
 	if !config.Conf.EnableBackup && !config.Conf.EnableMonitor {
@@ -60,34 +79,33 @@ func main() {
 	}
 
 	if config.Conf.EnableBackup {
-		lastBackup := ""
 		if config.Conf.BackupOnStartup {
 			log.Info("Running DockerRight on startup")
 			err := docker.BackupContainers()
 			if err != nil {
 				log.Error(err)
-			} else {
-				lastBackup = time.Now().Format("2006-01-02T15")
 			}
 		}
-
-		for _, hour := range config.Conf.BackupHours {
-			cSchedule := fmt.Sprintf("5 %v * * *", hour)
-			_, err := c.AddFunc(cSchedule, func() {
-				curBackup := time.Now().Format("2006-01-02T15")
-				if curBackup != lastBackup {
-					log.Debug("Running backup at hour: ", hour)
-					err := docker.BackupContainers()
-					if err != nil {
-						log.Error(err)
-					}
-				} else {
-					log.Warn("Backup already ran at hour: ", hour, "\n", "This should only happen on startup and if you are running a backup on startup!")
+		if config.Conf.BackupCatchUp {
+			// Runs next to the schedules, the run lock serializes them
+			go func() {
+				log.Info("Catching up missed backups")
+				err := docker.CatchUpBackups()
+				if err != nil {
+					log.Error(err)
 				}
-			})
-			if err != nil {
-				log.Panic("Error adding backup cronjob: ", err)
-			}
+			}()
+		}
+
+		// A cronjob per schedule group, as schedules can be set per container via config or label.
+		// Containers come and go, so the jobs are synced every few minutes.
+		backupJobs := map[string]cron.EntryID{}
+		syncBackupJobs(c, backupJobs)
+		_, err := c.AddFunc("*/5 * * * *", func() {
+			syncBackupJobs(c, backupJobs)
+		})
+		if err != nil {
+			log.Panic("Error adding backup schedule sync cronjob: ", err)
 		}
 	}
 
@@ -97,8 +115,149 @@ func main() {
 	select {}
 }
 
+func runCommand(cmd string, args []string) {
+	switch cmd {
+	case "restore":
+		if len(args) < 1 {
+			log.Fatal("Usage: DockerRight restore <container> [snapshot|latest] [mountDestination...]")
+		}
+		snapshot := "latest"
+		if len(args) > 1 {
+			snapshot = args[1]
+		}
+		mounts := []string{}
+		if len(args) > 2 {
+			mounts = args[2:]
+		}
+		err := docker.RestoreContainer(args[0], snapshot, mounts...)
+		if err != nil {
+			log.Fatal("Error restoring container: ", err)
+		}
+	case "restore-dump":
+		if len(args) < 1 || len(args) > 2 {
+			log.Fatal("Usage: DockerRight restore-dump <container> [snapshot|latest]")
+		}
+		snapshot := "latest"
+		if len(args) > 1 {
+			snapshot = args[1]
+		}
+		err := docker.RestoreDump(args[0], snapshot)
+		if err != nil {
+			log.Fatal("Error restoring database dump: ", err)
+		}
+	case "snapshots":
+		if len(args) != 1 {
+			log.Fatal("Usage: DockerRight snapshots <container>")
+		}
+		snapshots, err := docker.ListSnapshots(args[0])
+		if err != nil {
+			log.Fatal("Error listing snapshots: ", err)
+		}
+		for _, s := range snapshots {
+			fmt.Println(s)
+		}
+	case "verify":
+		if len(args) < 1 || len(args) > 2 {
+			log.Fatal("Usage: DockerRight verify <container> [snapshot|latest|all]")
+		}
+		snapshots, err := docker.ListSnapshots(args[0])
+		if err != nil {
+			log.Fatal("Error listing snapshots: ", err)
+		}
+		if len(snapshots) == 0 {
+			log.Fatal("No snapshots found for container ", args[0])
+		}
+		if len(args) == 1 || args[1] == "latest" {
+			snapshots = snapshots[len(snapshots)-1:]
+		} else if args[1] != "all" {
+			snapshots = []string{args[1]}
+		}
+
+		failed := false
+		for _, s := range snapshots {
+			err := docker.VerifySnapshot(args[0], s)
+			if err != nil {
+				log.Error(err)
+				failed = true
+			}
+		}
+		if failed {
+			log.Fatal("Verification failed!")
+		}
+		log.Info("Verification successful")
+	case "prune":
+		if len(args) > 1 || (len(args) == 1 && args[0] != "--dry-run") {
+			log.Fatal("Usage: DockerRight prune [--dry-run]")
+		}
+		dryRun := len(args) == 1
+		results, err := docker.Prune(dryRun)
+		if err != nil {
+			log.Fatal("Error pruning backups: ", err)
+		}
+		for _, r := range results {
+			if r.Keep {
+				fmt.Println("keep ", r.Target, r.Container, r.Snapshot, strings.Join(r.Reasons, ", "))
+			} else {
+				fmt.Println("prune", r.Target, r.Container, r.Snapshot, "not matched by the retention policy")
+			}
+		}
+	default:
+		log.Fatal("Unknown command: ", cmd, "\n", "Available commands: restore, restore-dump, snapshots, verify, prune")
+	}
+}
+
+// syncBackupJobs registers a cronjob for every schedule group (containers with the same schedule) and removes the ones no longer in use.
+func syncBackupJobs(c *cron.Cron, jobs map[string]cron.EntryID) {
+	schedules, err := docker.BackupSchedules()
+	if err != nil {
+		log.Error("Error reading backup schedules: ", err)
+		return
+	}
+
+	for spec, containers := range schedules {
+		if _, ok := jobs[spec]; ok {
+			continue
+		}
+		schedule, err := docker.ParseSchedule(spec)
+		if err != nil {
+			log.Error("Error parsing backup schedule '", spec, "': ", err)
+			continue
+		}
+		spec := spec
+		// Overlapping runs are prevented by the run lock
+		id := c.Schedule(schedule, cron.FuncJob(func() {
+			log.Debug("Running backup schedule: ", spec)
+			err := docker.BackupContainersForSchedule(spec)
+			if err != nil {
+				log.Error(err)
+			}
+		}))
+		jobs[spec] = id
+		log.Info("Added backup schedule '", spec, "' for ", containers)
+	}
+
+	for spec, id := range jobs {
+		if _, ok := schedules[spec]; !ok {
+			c.Remove(id)
+			delete(jobs, spec)
+			log.Info("Removed backup schedule '", spec, "', no container uses it anymore")
+		}
+	}
+}
+
+// monitorLoop handles the Docker events of the containers right away and polls all containers every interval,
+// as a fallback for missed events. Polled states only notify after monitorRetries polls.
 func monitorLoop(intervalSec, monitorRetries int) {
-	containerInfos := []docker.ContainerInfo{}
+	// Events missed while DockerRight was down are replayed, at most of the last hour
+	containerInfos, savedAt := docker.LoadMonitorState()
+	since := time.Now()
+	if !savedAt.IsZero() {
+		since = since.Add(-time.Hour)
+		if savedAt.After(since) {
+			since = savedAt
+		}
+	}
+	events := docker.WatchEvents(since)
 	ticker := time.NewTicker(time.Duration(intervalSec) * time.Second)
 	for {
 		err := docker.MonitorContainers(&containerInfos)
@@ -106,13 +265,17 @@ func monitorLoop(intervalSec, monitorRetries int) {
 			log.MonitorMsg(err)
 		}
 
+		for i := range containerInfos {
+			checkRestarts(&containerInfos[i])
+			checkFlapping(&containerInfos[i])
+		}
+
 		for i, ci := range containerInfos {
 			if len(ci.States) < monitorRetries {
 				containerInfos[i].MonitorState = "unknown"
-				break
+				continue
 			}
 
-			isRunning := false
 			isRunningCount := 0
 
 			for _, s := range ci.States[len(ci.States)-monitorRetries:] {
@@ -122,24 +285,9 @@ func monitorLoop(intervalSec, monitorRetries int) {
 			}
 
 			if isRunningCount >= monitorRetries {
-				isRunning = true
-			}
-
-			if isRunning {
-				if ci.MonitorState == "unknown" || ci.MonitorState == "" {
-					containerInfos[i].MonitorState = "running"
-				} else if ci.MonitorState == "stopped" || ci.MonitorState == "unhealthy" || ci.MonitorState == "exited" {
-					containerInfos[i].MonitorState = "running"
-					log.MonitorMsg(ci.Name, " is UP and running again :)")
-				}
+				setRunning(&containerInfos[i])
 			} else if isRunningCount == 0 {
-				if ci.MonitorState != "stopped" && ci.MonitorState != "unhealthy" && ci.MonitorState != "exited" {
-					curState := ci.States[len(ci.States)-1]
-					containerInfos[i].MonitorState = curState
-					log.MonitorMsg(ci.Name, " is ", curState, "!")
-				} else {
-					log.Debug("Container stopped but not changed!")
-				}
+				setDown(&containerInfos[i], ci.States[len(ci.States)-1])
 			}
 
 			if len(ci.States) >= monitorRetries*4 {
@@ -147,7 +295,151 @@ func monitorLoop(intervalSec, monitorRetries int) {
 			}
 		}
 
+		saveMonitorState(containerInfos)
+
 		log.Info("Sleeping for ", intervalSec, "...")
-		<-ticker.C
+		for waiting := true; waiting; {
+			select {
+			case ev := <-events:
+				handleContainerEvent(&containerInfos, ev)
+				saveMonitorState(containerInfos)
+			case <-ticker.C:
+				waiting = false
+			}
+		}
 	}
 }
+
+func saveMonitorState(contInfos []docker.ContainerInfo) {
+	err := docker.SaveMonitorState(contInfos)
+	if err != nil {
+		log.Error("Error saving monitor state: ", err)
+	}
+}
+
+// handleContainerEvent applies a Docker event to the monitor state right away.
+func handleContainerEvent(contInfos *[]docker.ContainerInfo, ev docker.ContainerEvent) {
+	findContainer := func() int {
+		return slices.IndexFunc(*contInfos, func(ci docker.ContainerInfo) bool { return ci.ID == ev.ID })
+	}
+
+	// Added, removed and recreated containers are handled by the sync
+	i := findContainer()
+	if i == -1 || ev.State == "removed" {
+		err := docker.SyncContainers(contInfos)
+		if err != nil {
+			log.Error("Error syncing containers: ", err)
+			return
+		}
+		i = findContainer()
+	}
+	if i == -1 || ev.State == "removed" {
+		return
+	}
+	ci := &(*contInfos)[i]
+
+	err := docker.InspectContainer(ci)
+	if err != nil {
+		log.Error("Error inspecting container ", ci.Name, ": ", err)
+	}
+
+	switch ev.State {
+	case "oom":
+		ci.AlertEvent(ev, ci.Name, " ran out of memory, a process was killed by the OOM killer!")
+	case "running", "healthy":
+		ci.States = append(ci.States, ev.State)
+		setRunning(ci)
+	default:
+		if ev.State == "exited" {
+			// Notified by setDown
+			ci.NewExit = false
+		}
+		ci.States = append(ci.States, ev.State)
+		setDown(ci, ev.State)
+	}
+	checkRestarts(ci)
+}
+
+// checkRestarts notifies about crashes the container was already restarted from and about restart loops.
+func checkRestarts(ci *docker.ContainerInfo) {
+	if ci.NewExit {
+		ci.NewExit = false
+		// Exits of a container that is still down are notified by setDown
+		if ci.ExitCode != 0 && !isDown(ci.MonitorState) && !ci.Flapping && ci.StartedAt.After(ci.FinishedAt) {
+			ci.Alert(ci.Name, " crashed and was restarted (", ci.ExitDetails(), ")!")
+		}
+	}
+
+	if len(ci.Restarts) > config.Conf.MonitorRestartLimit && !ci.RestartLoop {
+		ci.RestartLoop = true
+		ci.Alert(ci.Name, " is restarting in a loop, ", len(ci.Restarts), " restarts in the last ", config.Conf.MonitorRestartWindowMinutes, " minutes (", ci.ExitDetails(), ")!")
+	} else if len(ci.Restarts) <= config.Conf.MonitorRestartLimit && ci.RestartLoop {
+		ci.RestartLoop = false
+		ci.Alert(ci.Name, " stopped restarting in a loop :)")
+	}
+}
+
+// setRunning marks a container as running and notifies if it was down before.
+func setRunning(ci *docker.ContainerInfo) {
+	if isDown(ci.MonitorState) {
+		notifyTransition(ci, ci.Name, " is UP and running again :)")
+	}
+	ci.MonitorState = "running"
+}
+
+// setDown marks a container as down and notifies if it wasn't down before.
+func setDown(ci *docker.ContainerInfo, curState string) {
+	if isDown(ci.MonitorState) {
+		log.Debug("Container stopped but not changed!")
+		return
+	}
+	ci.MonitorState = curState
+	if curState == "exited" || curState == "dead" {
+		notifyTransition(ci, ci.Name, " is ", curState, "! (", ci.ExitDetails(), ")")
+	} else if curState == "unhealthy" && ci.ProbeError != "" {
+		notifyTransition(ci, ci.Name, " is ", curState, "! (probe: ", ci.ProbeError, ")")
+	} else {
+		notifyTransition(ci, ci.Name, " is ", curState, "!")
+	}
+}
+
+// notifyTransition records an up/down state change and notifies about it, unless the container is flapping.
+// Reaching MonitorFlapThreshold changes within the window sends a single flapping notification instead.
+func notifyTransition(ci *docker.ContainerInfo, msg ...interface{}) {
+	now := time.Now()
+	ci.Transitions = append(recentTransitions(ci.Transitions, now), now)
+
+	if ci.Flapping {
+		log.Info("Not notifying, ", ci.Name, " is flapping: ", fmt.Sprint(msg...))
+		return
+	}
+	if len(ci.Transitions) >= config.Conf.MonitorFlapThreshold {
+		ci.Flapping = true
+		ci.Alert(ci.Name, " is flapping, ", len(ci.Transitions), " state changes in the last ", config.Conf.MonitorFlapWindowMinutes, " minutes! State changes are not notified until it is stable again")
+		return
+	}
+	ci.Alert(msg...)
+}
+
+// checkFlapping notifies once a flapping container didn't change its state for MonitorFlapWindowMinutes.
+func checkFlapping(ci *docker.ContainerInfo) {
+	ci.Transitions = recentTransitions(ci.Transitions, time.Now())
+	if ci.Flapping && len(ci.Transitions) == 0 {
+		ci.Flapping = false
+		ci.Alert(ci.Name, " stopped flapping, it is stable for ", config.Conf.MonitorFlapWindowMinutes, " minutes and ", ci.MonitorState, " :)")
+	}
+}
+
+// recentTransitions drops the transitions older than MonitorFlapWindowMinutes.
+func recentTransitions(transitions []time.Time, now time.Time) []time.Time {
+	window := now.Add(-time.Duration(config.Conf.MonitorFlapWindowMinutes) * time.Minute)
+	for len(transitions) > 0 && transitions[0].Before(window) {
+		transitions = transitions[1:]
+	}
+	return transitions
+}
+
+// isDown is true for every notified state except running, i.e. exited, dead, paused, restarting or unhealthy.
+func isDown(monitorState string) bool {
+	return monitorState != "" && monitorState != "unknown" && monitorState != "running"
+}
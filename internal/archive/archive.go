@@ -0,0 +1,116 @@
+package archive
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// Extensions contains every archive extension DockerRight writes, longest first.
+var Extensions = []string{".tar.gz", ".tar.zst", ".tar"}
+
+func ValidCompression(compression string) bool {
+	switch strings.ToLower(compression) {
+	case CompressionNone, CompressionGzip, CompressionZstd, "":
+		return true
+	default:
+		return false
+	}
+}
+
+// ValidCompressionLevel checks the level against the range of the compression (gzip 1-9, zstd 1-22), 0 is the default level.
+func ValidCompressionLevel(compression string, level int) bool {
+	if level == 0 {
+		return true
+	}
+	switch strings.ToLower(compression) {
+	case CompressionGzip:
+		return level >= gzip.BestSpeed && level <= gzip.BestCompression
+	case CompressionZstd:
+		return level >= 1 && level <= 22
+	default:
+		return true
+	}
+}
+
+func Extension(compression string) string {
+	return ".tar" + CompressionExtension(compression)
+}
+
+// CompressionExtension returns the extension of the compression alone, i.e. for database dumps.
+func CompressionExtension(compression string) string {
+	switch strings.ToLower(compression) {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// TrimExtension strips a known (encrypted) archive extension from fileName, ok is false if there was none.
+func TrimExtension(fileName string) (string, bool) {
+	fileName = strings.TrimSuffix(fileName, EncryptionExtension)
+	for _, ext := range Extensions {
+		if strings.HasSuffix(fileName, ext) {
+			return strings.TrimSuffix(fileName, ext), true
+		}
+	}
+	return fileName, false
+}
+
+// NewWriter wraps w with the configured compression, level 0 selects the default level.
+// The returned writer must be closed to flush the archive, w is not closed.
+func NewWriter(w io.Writer, compression string, level int) (io.WriteCloser, error) {
+	switch strings.ToLower(compression) {
+	case CompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case CompressionZstd:
+		opts := []zstd.EOption{}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	case CompressionNone, "":
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, errors.New("Unknown compression: " + compression)
+	}
+}
+
+// NewReader decompresses r based on the extension of fileName, a trailing EncryptionExtension is ignored.
+// Files without a compression extension are passed through.
+func NewReader(r io.Reader, fileName string) (io.ReadCloser, error) {
+	fileName = strings.TrimSuffix(fileName, EncryptionExtension)
+	switch {
+	case strings.HasSuffix(fileName, ".gz"):
+		return gzip.NewReader(r)
+	case strings.HasSuffix(fileName, ".zst"):
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return io.NopCloser(r), nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,134 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWriterReaderRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("DockerRight archive content\n", 1000))
+
+	tests := []struct {
+		compression string
+		level       int
+	}{
+		{CompressionNone, 0},
+		{"", 0},
+		{CompressionGzip, 0},
+		{CompressionGzip, 1},
+		{CompressionGzip, 9},
+		{CompressionZstd, 0},
+		{CompressionZstd, 1},
+		{CompressionZstd, 19},
+		{"GZIP", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.compression, "/", tt.level), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, tt.compression, tt.level)
+			if err != nil {
+				t.Fatalf("NewWriter() error = %v", err)
+			}
+			if _, err := w.Write(data); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			r, err := NewReader(&buf, "mount"+Extension(tt.compression))
+			if err != nil {
+				t.Fatalf("NewReader() error = %v", err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("round trip returned %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestNewWriterUnknownCompression(t *testing.T) {
+	if _, err := NewWriter(io.Discard, "bzip2", 0); err == nil {
+		t.Error("NewWriter() with unknown compression returned no error")
+	}
+}
+
+func TestNewReaderInvalidData(t *testing.T) {
+	if _, err := NewReader(strings.NewReader("not gzip"), "mount.tar.gz"); err == nil {
+		t.Error("NewReader() of invalid gzip data returned no error")
+	}
+}
+
+func TestExtension(t *testing.T) {
+	tests := []struct {
+		compression string
+		want        string
+	}{
+		{CompressionNone, ".tar"},
+		{"", ".tar"},
+		{CompressionGzip, ".tar.gz"},
+		{CompressionZstd, ".tar.zst"},
+		{"ZSTD", ".tar.zst"},
+	}
+
+	for _, tt := range tests {
+		if got := Extension(tt.compression); got != tt.want {
+			t.Errorf("Extension(%q) = %q, want %q", tt.compression, got, tt.want)
+		}
+	}
+}
+
+func TestTrimExtension(t *testing.T) {
+	tests := []struct {
+		fileName string
+		want     string
+		wantOk   bool
+	}{
+		{"data.tar", "data", true},
+		{"data.tar.gz", "data", true},
+		{"data.tar.zst", "data", true},
+		{"data.tar.gz.age", "data", true},
+		{"data.tar.zst.age", "data", true},
+		{"data.sql.gz", "data.sql.gz", false},
+		{"data.log", "data.log", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := TrimExtension(tt.fileName)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("TrimExtension(%q) = %q, %v, want %q, %v", tt.fileName, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func TestValidCompressionLevel(t *testing.T) {
+	tests := []struct {
+		compression string
+		level       int
+		want        bool
+	}{
+		{CompressionGzip, 0, true},
+		{CompressionGzip, 1, true},
+		{CompressionGzip, 9, true},
+		{CompressionGzip, 10, false},
+		{CompressionGzip, -1, false},
+		{CompressionZstd, 22, true},
+		{CompressionZstd, 23, false},
+		{CompressionNone, 5, true},
+	}
+
+	for _, tt := range tests {
+		if got := ValidCompressionLevel(tt.compression, tt.level); got != tt.want {
+			t.Errorf("ValidCompressionLevel(%q, %d) = %v, want %v", tt.compression, tt.level, got, tt.want)
+		}
+	}
+}
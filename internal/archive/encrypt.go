@@ -0,0 +1,108 @@
+package archive
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// EncryptionExtension is appended to the archive extension of encrypted archives.
+const EncryptionExtension = ".age"
+
+type Encryption struct {
+	// Recipients are age X25519 public keys (age1...), every recipient can decrypt the archive.
+	Recipients []string
+	// Passphrase derives the key via scrypt, it can't be combined with Recipients.
+	Passphrase string
+	// IdentityFile contains the age private keys used for decryption.
+	IdentityFile string
+}
+
+func (e Encryption) Enabled() bool {
+	return len(e.Recipients) != 0 || e.Passphrase != ""
+}
+
+func (e Encryption) Validate() error {
+	if len(e.Recipients) != 0 && e.Passphrase != "" {
+		return errors.New("Encryption recipients and passphrase can't be used together")
+	}
+	_, err := e.recipients()
+	return err
+}
+
+func (e Encryption) recipients() ([]age.Recipient, error) {
+	recipients := []age.Recipient{}
+	if e.Passphrase != "" {
+		r, err := age.NewScryptRecipient(e.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, r)
+	}
+	for _, key := range e.Recipients {
+		r, err := age.ParseX25519Recipient(strings.TrimSpace(key))
+		if err != nil {
+			return nil, errors.New("Error parsing encryption recipient: " + err.Error())
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, nil
+}
+
+func (e Encryption) identities() ([]age.Identity, error) {
+	identities := []age.Identity{}
+	if e.Passphrase != "" {
+		i, err := age.NewScryptIdentity(e.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	if e.IdentityFile != "" {
+		f, err := os.Open(e.IdentityFile)
+		if err != nil {
+			return nil, errors.New("Error opening identity file: " + err.Error())
+		}
+		defer f.Close()
+
+		ids, err := age.ParseIdentities(f)
+		if err != nil {
+			return nil, errors.New("Error parsing identity file: " + err.Error())
+		}
+		identities = append(identities, ids...)
+	}
+	if len(identities) == 0 {
+		return nil, errors.New("No passphrase or identity file configured to decrypt the archive")
+	}
+	return identities, nil
+}
+
+// NewEncryptWriter encrypts everything written to it for the configured recipients.
+// If encryption is disabled w is passed through. The writer must be closed, w is not closed.
+func NewEncryptWriter(w io.Writer, e Encryption) (io.WriteCloser, error) {
+	if !e.Enabled() {
+		return nopWriteCloser{w}, nil
+	}
+
+	recipients, err := e.recipients()
+	if err != nil {
+		return nil, err
+	}
+	return age.Encrypt(w, recipients...)
+}
+
+// NewDecryptReader decrypts r if fileName has the EncryptionExtension, otherwise r is returned as is.
+func NewDecryptReader(r io.Reader, fileName string, e Encryption) (io.Reader, error) {
+	if !strings.HasSuffix(fileName, EncryptionExtension) {
+		return r, nil
+	}
+
+	identities, err := e.identities()
+	if err != nil {
+		return nil, err
+	}
+	return age.Decrypt(r, identities...)
+}
@@ -0,0 +1,104 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherFile := filepath.Join(t.TempDir(), "other.txt")
+	if err := os.WriteFile(otherFile, []byte(other.String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("secret volume content")
+
+	tests := []struct {
+		name     string
+		encrypt  Encryption
+		decrypt  Encryption
+		fileName string
+		wantErr  bool
+	}{
+		{"disabled", Encryption{}, Encryption{}, "data.tar.gz", false},
+		{"recipient", Encryption{Recipients: []string{identity.Recipient().String()}}, Encryption{IdentityFile: identityFile}, "data.tar.gz.age", false},
+		{"passphrase", Encryption{Passphrase: "correct horse"}, Encryption{Passphrase: "correct horse"}, "data.tar.age", false},
+		{"wrong identity", Encryption{Recipients: []string{identity.Recipient().String()}}, Encryption{IdentityFile: otherFile}, "data.tar.gz.age", true},
+		{"no identity", Encryption{Recipients: []string{identity.Recipient().String()}}, Encryption{}, "data.tar.gz.age", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewEncryptWriter(&buf, tt.encrypt)
+			if err != nil {
+				t.Fatalf("NewEncryptWriter() error = %v", err)
+			}
+			if _, err := w.Write(data); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			if tt.encrypt.Enabled() && bytes.Contains(buf.Bytes(), data) {
+				t.Fatal("encrypted archive contains the plaintext")
+			}
+
+			r, err := NewDecryptReader(&buf, tt.fileName, tt.decrypt)
+			if err == nil {
+				var got []byte
+				got, err = io.ReadAll(r)
+				if err == nil && !bytes.Equal(got, data) {
+					t.Errorf("round trip returned %q, want %q", got, data)
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decrypt error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncryptionValidate(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		e       Encryption
+		wantErr bool
+	}{
+		{"disabled", Encryption{}, false},
+		{"recipient", Encryption{Recipients: []string{identity.Recipient().String()}}, false},
+		{"passphrase", Encryption{Passphrase: "secret"}, false},
+		{"invalid recipient", Encryption{Recipients: []string{"age1invalid"}}, true},
+		{"recipient and passphrase", Encryption{Recipients: []string{identity.Recipient().String()}, Passphrase: "secret"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.e.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,13 +3,21 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"net/url"
 	"os"
 	// "os/user"
+	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 
+	"github.com/robfig/cron/v3"
+
+	"github.com/bata94/DockerRight/internal/archive"
 	"github.com/bata94/DockerRight/internal/log"
+	"github.com/bata94/DockerRight/internal/retention"
+	"github.com/bata94/DockerRight/internal/storage"
 )
 
 var (
@@ -17,6 +25,9 @@ var (
 	Conf       Config
 )
 
+// redacted replaces secrets in the logged config.
+const redacted = "***"
+
 func Init(configPath string) Config {
 	log.Info("Initializing Config Module")
 	var err error
@@ -59,23 +70,107 @@ type Config struct {
 	EnableMonitor                bool
 	MonitorIntervalSeconds       int
 	MonitorRetries               int
+	MonitorRestartLimit          int
+	MonitorRestartWindowMinutes  int
+	MonitorFlapThreshold         int
+	MonitorFlapWindowMinutes     int
+	MonitorCPUPercent            int
+	MonitorMemoryPercent         int
+	MonitorBlockIOMBps           int
+	MonitorNetworkMBps           int
+	MonitorStatsWindowSeconds    int
+	MonitorProbes                map[string]Probe
+	MonitorProbeTimeoutSeconds   int
 	BackupHours                  []int
+	BackupSchedule               []string
+	BackupContainerSchedules     map[string][]string
 	RetentionHours               int
+	RetentionPolicy              string
 	LogRetentionDays             int
 	ConcurrentBackupContainer    int
+	BackupCompression            string
+	BackupCompressionLevel       int
+	BackupEncryptionRecipients   []string
+	BackupEncryptionPassphrase   string
+	BackupEncryptionIdentityFile string
+	BackupQuiesce                string
+	BackupQuiesceTimeoutSeconds  int
+	BackupIncremental            bool
+	BackupIncrementalFullEvery   int
 	BackupPath                   string
+	StorageBackend               string
+	S3Endpoint                   string
+	S3Bucket                     string
+	S3Prefix                     string
+	S3Region                     string
+	S3AccessKey                  string
+	S3SecretKey                  string
+	S3UseSSL                     bool
+	S3PartSizeMB                 int
+	SFTPHost                     string
+	SFTPPort                     int
+	SFTPUser                     string
+	SFTPKeyFile                  string
+	SFTPKnownHostsFile           string
+	SFTPPath                     string
+	BackupTargets                []BackupTarget
 	LogsPath                     string
 	BeforeBackupCMD              string
 	AfterBackupCMD               string
 	LogLevel                     string
 	Log2File                     bool
 	BackupOnStartup              bool
+	BackupCatchUp                bool
 	CreateTestContainerOnStartup bool
 	NotifyLevel                  string
 	TelegramChatIDs              []int
 	TelegramBotToken             string
 }
 
+// BackupTarget is a destination every snapshot is replicated to, with its own retention.
+type BackupTarget struct {
+	Name string
+	// Backend is local, s3 or sftp, Path is the directory of local targets.
+	Backend string
+	Path    string
+	S3      *storage.S3Config   `json:",omitempty"`
+	SFTP    *storage.SFTPConfig `json:",omitempty"`
+	// RetentionPolicy overrides the retention of all containers on this target, empty uses the container/global retention.
+	RetentionPolicy string
+}
+
+// Probe checks a container without a Docker HEALTHCHECK, i.e. "http://:8080/health" or "tcp://:5432".
+// Without a host in the URL the network address of the container is used.
+type Probe struct {
+	URL string
+	// Status is the expected HTTP status, 0 accepts every 2xx and 3xx status.
+	Status int `json:",omitempty"`
+	// Body is a regular expression the HTTP response body has to match, empty accepts every body.
+	Body string `json:",omitempty"`
+}
+
+// Validate checks the URL and the body regex of the probe.
+func (p Probe) Validate() error {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "tcp":
+		if u.Port() == "" {
+			return errors.New("TCP probe '" + p.URL + "' needs a port")
+		}
+	default:
+		return errors.New("Probe '" + p.URL + "' has an unknown scheme, valid schemes are http, https and tcp")
+	}
+	_, err = regexp.Compile(p.Body)
+	if err != nil {
+		return errors.New("Probe body '" + p.Body + "' is not a valid regular expression: " + err.Error())
+	}
+	return nil
+}
+
 func (c *Config) SetDefaults() error {
 	log.Info("Config SetDefaults")
 
@@ -83,18 +178,58 @@ func (c *Config) SetDefaults() error {
 	c.EnableBackup = false
 	c.EnableMonitor = false
 	c.RetentionHours = 24 * 5
+	c.RetentionPolicy = ""
 	c.LogRetentionDays = 7
 	c.MonitorIntervalSeconds = 60
 	c.MonitorRetries = 5
+	c.MonitorRestartLimit = 3
+	c.MonitorRestartWindowMinutes = 10
+	c.MonitorFlapThreshold = 6
+	c.MonitorFlapWindowMinutes = 15
+	c.MonitorCPUPercent = 0
+	c.MonitorMemoryPercent = 0
+	c.MonitorBlockIOMBps = 0
+	c.MonitorNetworkMBps = 0
+	c.MonitorStatsWindowSeconds = 300
+	c.MonitorProbes = map[string]Probe{}
+	c.MonitorProbeTimeoutSeconds = 5
 	c.BackupHours = []int{}
+	c.BackupSchedule = []string{}
+	c.BackupContainerSchedules = map[string][]string{}
 	c.ConcurrentBackupContainer = (runtime.NumCPU() / 2)
+	c.BackupCompression = archive.CompressionNone
+	c.BackupCompressionLevel = 0
+	c.BackupEncryptionRecipients = []string{}
+	c.BackupEncryptionPassphrase = ""
+	c.BackupEncryptionIdentityFile = ""
+	c.BackupQuiesce = "none"
+	c.BackupQuiesceTimeoutSeconds = 60
+	c.BackupIncremental = false
+	c.BackupIncrementalFullEvery = 7
 	c.BackupPath = "/opt/DockerRight/backup"
+	c.StorageBackend = "local"
+	c.S3Endpoint = ""
+	c.S3Bucket = ""
+	c.S3Prefix = ""
+	c.S3Region = ""
+	c.S3AccessKey = ""
+	c.S3SecretKey = ""
+	c.S3UseSSL = true
+	c.S3PartSizeMB = 64
+	c.SFTPHost = ""
+	c.SFTPPort = 22
+	c.SFTPUser = ""
+	c.SFTPKeyFile = ""
+	c.SFTPKnownHostsFile = ""
+	c.SFTPPath = ""
+	c.BackupTargets = []BackupTarget{}
 	c.LogsPath = "/opt/DockerRight/logs"
 	c.Log2File = false
 	c.BeforeBackupCMD = ""
 	c.AfterBackupCMD = ""
 	c.LogLevel = "info"
 	c.BackupOnStartup = false
+	c.BackupCatchUp = false
 	c.CreateTestContainerOnStartup = true
 	c.NotifyLevel = "warn"
 	c.TelegramBotToken = ""
@@ -113,7 +248,130 @@ func (c *Config) Load() error {
 	if err != nil {
 		return err
 	}
-	log.Info("Config: ", log.FormatStruct(Conf))
+	if !archive.ValidCompression(Conf.BackupCompression) {
+		log.Error("BackupCompression '", Conf.BackupCompression, "' is unknown, valid values are none, gzip and zstd")
+		log.Warn("Falling back to no compression!")
+		Conf.BackupCompression = archive.CompressionNone
+	}
+	if !archive.ValidCompressionLevel(Conf.BackupCompression, Conf.BackupCompressionLevel) {
+		log.Error("BackupCompressionLevel ", Conf.BackupCompressionLevel, " is out of range for ", Conf.BackupCompression, ", valid levels are 1-9 for gzip and 1-22 for zstd")
+		log.Warn("Falling back to the default level!")
+		Conf.BackupCompressionLevel = 0
+	}
+	switch strings.ToLower(Conf.BackupQuiesce) {
+	case "none", "pause", "stop", "":
+	default:
+		log.Error("BackupQuiesce '", Conf.BackupQuiesce, "' is unknown, valid values are none, pause and stop")
+		log.Warn("Falling back to none!")
+		Conf.BackupQuiesce = "none"
+	}
+	if Conf.MonitorRestartLimit < 1 {
+		log.Error("MonitorRestartLimit has to be at least 1, value read: ", Conf.MonitorRestartLimit)
+		log.Warn("Falling back to 3!")
+		Conf.MonitorRestartLimit = 3
+	}
+	if Conf.MonitorRestartWindowMinutes < 1 {
+		log.Error("MonitorRestartWindowMinutes has to be at least 1, value read: ", Conf.MonitorRestartWindowMinutes)
+		log.Warn("Falling back to 10!")
+		Conf.MonitorRestartWindowMinutes = 10
+	}
+	if Conf.MonitorFlapThreshold < 2 {
+		log.Error("MonitorFlapThreshold has to be at least 2, value read: ", Conf.MonitorFlapThreshold)
+		log.Warn("Falling back to 6!")
+		Conf.MonitorFlapThreshold = 6
+	}
+	if Conf.MonitorFlapWindowMinutes < 1 {
+		log.Error("MonitorFlapWindowMinutes has to be at least 1, value read: ", Conf.MonitorFlapWindowMinutes)
+		log.Warn("Falling back to 15!")
+		Conf.MonitorFlapWindowMinutes = 15
+	}
+	if Conf.MonitorStatsWindowSeconds < 0 {
+		log.Error("MonitorStatsWindowSeconds can't be negative, value read: ", Conf.MonitorStatsWindowSeconds)
+		log.Warn("Falling back to 300!")
+		Conf.MonitorStatsWindowSeconds = 300
+	}
+	if Conf.BackupIncrementalFullEvery < 1 {
+		log.Error("BackupIncrementalFullEvery has to be at least 1, value read: ", Conf.BackupIncrementalFullEvery)
+		log.Warn("Falling back to 7!")
+		Conf.BackupIncrementalFullEvery = 7
+	}
+	switch Conf.StorageBackend {
+	case "local", "s3", "sftp":
+	default:
+		return errors.New("StorageBackend '" + Conf.StorageBackend + "' is unknown, valid values are local, s3 and sftp")
+	}
+	for _, spec := range Conf.BackupSchedule {
+		_, err = cron.ParseStandard(spec)
+		if err != nil {
+			return errors.New("BackupSchedule '" + spec + "' is not a valid cron expression: " + err.Error())
+		}
+	}
+	for containerName, schedule := range Conf.BackupContainerSchedules {
+		for _, spec := range schedule {
+			_, err = cron.ParseStandard(spec)
+			if err != nil {
+				return errors.New("BackupContainerSchedules of " + containerName + ": '" + spec + "' is not a valid cron expression: " + err.Error())
+			}
+		}
+	}
+	for containerName, probe := range Conf.MonitorProbes {
+		err = probe.Validate()
+		if err != nil {
+			return errors.New("MonitorProbes of " + containerName + ": " + err.Error())
+		}
+	}
+	if Conf.MonitorProbeTimeoutSeconds < 1 {
+		log.Error("MonitorProbeTimeoutSeconds has to be at least 1, value read: ", Conf.MonitorProbeTimeoutSeconds)
+		log.Warn("Falling back to 5!")
+		Conf.MonitorProbeTimeoutSeconds = 5
+	}
+	for _, h := range Conf.BackupHours {
+		if h < 0 || h > 23 {
+			return errors.New("BackupHours contains an invalid hour: " + strconv.Itoa(h))
+		}
+	}
+	names := map[string]bool{}
+	for i, t := range Conf.BackupTargets {
+		if t.Name == "" || names[t.Name] {
+			return errors.New("BackupTargets need a unique Name, target " + strconv.Itoa(i) + " has '" + t.Name + "'")
+		}
+		names[t.Name] = true
+		switch t.Backend {
+		case "local":
+			if t.Path == "" {
+				return errors.New("BackupTarget " + t.Name + " needs a Path")
+			}
+		case "s3":
+			if t.S3 == nil {
+				return errors.New("BackupTarget " + t.Name + " needs an S3 config")
+			}
+		case "sftp":
+			if t.SFTP == nil {
+				return errors.New("BackupTarget " + t.Name + " needs an SFTP config")
+			}
+		default:
+			return errors.New("BackupTarget " + t.Name + ": Backend '" + t.Backend + "' is unknown, valid values are local, s3 and sftp")
+		}
+		if t.RetentionPolicy != "" {
+			_, err = retention.ParsePolicy(t.RetentionPolicy)
+			if err != nil {
+				return errors.New("BackupTarget " + t.Name + ": RetentionPolicy could not be parsed: " + err.Error())
+			}
+		}
+	}
+	if Conf.RetentionPolicy != "" {
+		_, err = retention.ParsePolicy(Conf.RetentionPolicy)
+		if err != nil {
+			log.Error("RetentionPolicy '", Conf.RetentionPolicy, "' could not be parsed: ", err)
+			log.Warn("Falling back to RetentionHours!")
+			Conf.RetentionPolicy = ""
+		}
+	}
+	err = Conf.BackupEncryption().Validate()
+	if err != nil {
+		return errors.New("Invalid backup encryption config: " + err.Error())
+	}
+	log.Info("Config: ", log.FormatStruct(Conf.Redacted()))
 	return nil
 }
 
@@ -155,6 +413,17 @@ func (c *Config) LoadFromEnv() error {
 			log.Warn("Falling back to value in 'config.json' or to default value!")
 		}
 	}
+	if os.Getenv("BACKUP_CATCH_UP") != "" {
+		backupCatchUp := strings.ToLower(os.Getenv("BACKUP_CATCH_UP"))
+		if backupCatchUp == "true" {
+			c.BackupCatchUp = true
+		} else if backupCatchUp == "false" {
+			c.BackupCatchUp = false
+		} else {
+			log.Error("Environment Variable 'BACKUP_CATCH_UP' could not be parsed... value read: ", backupCatchUp)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		}
+	}
 	if os.Getenv("CREATE_TEST_CONTAINER_ON_STARTUP") != "" {
 		createTestContainerOnStartup := strings.ToLower(os.Getenv("CREATE_TEST_CONTAINER_ON_STARTUP"))
 		if createTestContainerOnStartup == "true" {
@@ -177,6 +446,28 @@ func (c *Config) LoadFromEnv() error {
 			log.Warn("Falling back to value in 'config.json' or to default value!")
 		}
 	}
+	if os.Getenv("BACKUP_INCREMENTAL") != "" {
+		backupIncremental := strings.ToLower(os.Getenv("BACKUP_INCREMENTAL"))
+		if backupIncremental == "true" {
+			c.BackupIncremental = true
+		} else if backupIncremental == "false" {
+			c.BackupIncremental = false
+		} else {
+			log.Error("Environment Variable 'BACKUP_INCREMENTAL' could not be parsed... value read: ", backupIncremental)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		}
+	}
+	if os.Getenv("S3_USE_SSL") != "" {
+		s3UseSSL := strings.ToLower(os.Getenv("S3_USE_SSL"))
+		if s3UseSSL == "true" {
+			c.S3UseSSL = true
+		} else if s3UseSSL == "false" {
+			c.S3UseSSL = false
+		} else {
+			log.Error("Environment Variable 'S3_USE_SSL' could not be parsed... value read: ", s3UseSSL)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		}
+	}
 
 	// String Values
 	if os.Getenv("BACKUP_PATH") != "" {
@@ -185,6 +476,57 @@ func (c *Config) LoadFromEnv() error {
 	if os.Getenv("LOGS_PATH") != "" {
 		c.LogsPath = os.Getenv("LOGS_PATH")
 	}
+	if os.Getenv("STORAGE_BACKEND") != "" {
+		c.StorageBackend = strings.ToLower(os.Getenv("STORAGE_BACKEND"))
+	}
+	if os.Getenv("S3_ENDPOINT") != "" {
+		c.S3Endpoint = os.Getenv("S3_ENDPOINT")
+	}
+	if os.Getenv("S3_BUCKET") != "" {
+		c.S3Bucket = os.Getenv("S3_BUCKET")
+	}
+	if os.Getenv("S3_PREFIX") != "" {
+		c.S3Prefix = os.Getenv("S3_PREFIX")
+	}
+	if os.Getenv("S3_REGION") != "" {
+		c.S3Region = os.Getenv("S3_REGION")
+	}
+	if os.Getenv("S3_ACCESS_KEY") != "" {
+		c.S3AccessKey = os.Getenv("S3_ACCESS_KEY")
+	}
+	if os.Getenv("S3_SECRET_KEY") != "" {
+		c.S3SecretKey = os.Getenv("S3_SECRET_KEY")
+	}
+	if os.Getenv("SFTP_HOST") != "" {
+		c.SFTPHost = os.Getenv("SFTP_HOST")
+	}
+	if os.Getenv("SFTP_USER") != "" {
+		c.SFTPUser = os.Getenv("SFTP_USER")
+	}
+	if os.Getenv("SFTP_KEY_FILE") != "" {
+		c.SFTPKeyFile = os.Getenv("SFTP_KEY_FILE")
+	}
+	if os.Getenv("SFTP_KNOWN_HOSTS_FILE") != "" {
+		c.SFTPKnownHostsFile = os.Getenv("SFTP_KNOWN_HOSTS_FILE")
+	}
+	if os.Getenv("SFTP_PATH") != "" {
+		c.SFTPPath = os.Getenv("SFTP_PATH")
+	}
+	if os.Getenv("RETENTION_POLICY") != "" {
+		c.RetentionPolicy = os.Getenv("RETENTION_POLICY")
+	}
+	if os.Getenv("BACKUP_COMPRESSION") != "" {
+		c.BackupCompression = strings.ToLower(os.Getenv("BACKUP_COMPRESSION"))
+	}
+	if os.Getenv("BACKUP_ENCRYPTION_PASSPHRASE") != "" {
+		c.BackupEncryptionPassphrase = os.Getenv("BACKUP_ENCRYPTION_PASSPHRASE")
+	}
+	if os.Getenv("BACKUP_ENCRYPTION_IDENTITY_FILE") != "" {
+		c.BackupEncryptionIdentityFile = os.Getenv("BACKUP_ENCRYPTION_IDENTITY_FILE")
+	}
+	if os.Getenv("BACKUP_QUIESCE") != "" {
+		c.BackupQuiesce = strings.ToLower(os.Getenv("BACKUP_QUIESCE"))
+	}
 	if os.Getenv("BEFORE_BACKUP_CMD") != "" {
 		c.BeforeBackupCMD = os.Getenv("BEFORE_BACKUP_CMD")
 	}
@@ -230,6 +572,72 @@ func (c *Config) LoadFromEnv() error {
 			c.BackupHours = backupHours
 		}
 	}
+	if os.Getenv("BACKUP_SCHEDULE") != "" {
+		backupScheduleVar := os.Getenv("BACKUP_SCHEDULE")
+		backupSchedule := []string{}
+
+		// String cleanup, cron expressions contain spaces and commas, so they are separated by ";"
+		backupScheduleVar = strings.ReplaceAll(backupScheduleVar, "[", "")
+		backupScheduleVar = strings.ReplaceAll(backupScheduleVar, "]", "")
+
+		for _, spec := range strings.Split(backupScheduleVar, ";") {
+			spec = strings.TrimSpace(spec)
+			if spec != "" {
+				backupSchedule = append(backupSchedule, spec)
+			}
+		}
+
+		c.BackupSchedule = backupSchedule
+	}
+	if os.Getenv("BACKUP_CONTAINER_SCHEDULES") != "" {
+		containerSchedulesVar := os.Getenv("BACKUP_CONTAINER_SCHEDULES")
+		containerSchedules := map[string][]string{}
+
+		err := json.Unmarshal([]byte(containerSchedulesVar), &containerSchedules)
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'BACKUP_CONTAINER_SCHEDULES' could not be parsed... value read: ", containerSchedulesVar)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.BackupContainerSchedules = containerSchedules
+		}
+	}
+	if os.Getenv("MONITOR_PROBES") != "" {
+		probesVar := os.Getenv("MONITOR_PROBES")
+		probes := map[string]Probe{}
+
+		err := json.Unmarshal([]byte(probesVar), &probes)
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'MONITOR_PROBES' could not be parsed... value read: ", probesVar)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.MonitorProbes = probes
+		}
+	}
+	if os.Getenv("BACKUP_ENCRYPTION_RECIPIENTS") != "" {
+		recipientsVar := os.Getenv("BACKUP_ENCRYPTION_RECIPIENTS")
+
+		// String cleanup
+		recipientsVar = strings.ReplaceAll(recipientsVar, "[", "")
+		recipientsVar = strings.ReplaceAll(recipientsVar, "]", "")
+		recipientsVar = strings.ReplaceAll(recipientsVar, " ", "")
+
+		c.BackupEncryptionRecipients = strings.Split(recipientsVar, ",")
+	}
+	if os.Getenv("BACKUP_TARGETS") != "" {
+		backupTargetsVar := os.Getenv("BACKUP_TARGETS")
+		backupTargets := []BackupTarget{}
+
+		err := json.Unmarshal([]byte(backupTargetsVar), &backupTargets)
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'BACKUP_TARGETS' could not be parsed... value read: ", backupTargetsVar)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.BackupTargets = backupTargets
+		}
+	}
 	if os.Getenv("TELEGRAM_CHAT_IDS") != "" {
 		tgChatIDsVar := os.Getenv("TELEGRAM_CHAT_IDS")
 		tgChatIDs := []int{}
@@ -284,6 +692,126 @@ func (c *Config) LoadFromEnv() error {
 			c.MonitorRetries = valInt
 		}
 	}
+	if os.Getenv("MONITOR_RESTART_LIMIT") != "" {
+		val := os.Getenv("MONITOR_RESTART_LIMIT")
+		valInt, err := strconv.Atoi(val)
+
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'MONITOR_RESTART_LIMIT' could not be parsed... value read: ", val)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.MonitorRestartLimit = valInt
+		}
+	}
+	if os.Getenv("MONITOR_RESTART_WINDOW_MINUTES") != "" {
+		val := os.Getenv("MONITOR_RESTART_WINDOW_MINUTES")
+		valInt, err := strconv.Atoi(val)
+
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'MONITOR_RESTART_WINDOW_MINUTES' could not be parsed... value read: ", val)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.MonitorRestartWindowMinutes = valInt
+		}
+	}
+	if os.Getenv("MONITOR_FLAP_THRESHOLD") != "" {
+		val := os.Getenv("MONITOR_FLAP_THRESHOLD")
+		valInt, err := strconv.Atoi(val)
+
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'MONITOR_FLAP_THRESHOLD' could not be parsed... value read: ", val)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.MonitorFlapThreshold = valInt
+		}
+	}
+	if os.Getenv("MONITOR_FLAP_WINDOW_MINUTES") != "" {
+		val := os.Getenv("MONITOR_FLAP_WINDOW_MINUTES")
+		valInt, err := strconv.Atoi(val)
+
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'MONITOR_FLAP_WINDOW_MINUTES' could not be parsed... value read: ", val)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.MonitorFlapWindowMinutes = valInt
+		}
+	}
+	if os.Getenv("MONITOR_PROBE_TIMEOUT_SECONDS") != "" {
+		val := os.Getenv("MONITOR_PROBE_TIMEOUT_SECONDS")
+		valInt, err := strconv.Atoi(val)
+
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'MONITOR_PROBE_TIMEOUT_SECONDS' could not be parsed... value read: ", val)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.MonitorProbeTimeoutSeconds = valInt
+		}
+	}
+	if os.Getenv("MONITOR_CPU_PERCENT") != "" {
+		val := os.Getenv("MONITOR_CPU_PERCENT")
+		valInt, err := strconv.Atoi(val)
+
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'MONITOR_CPU_PERCENT' could not be parsed... value read: ", val)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.MonitorCPUPercent = valInt
+		}
+	}
+	if os.Getenv("MONITOR_MEMORY_PERCENT") != "" {
+		val := os.Getenv("MONITOR_MEMORY_PERCENT")
+		valInt, err := strconv.Atoi(val)
+
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'MONITOR_MEMORY_PERCENT' could not be parsed... value read: ", val)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.MonitorMemoryPercent = valInt
+		}
+	}
+	if os.Getenv("MONITOR_BLOCKIO_MBPS") != "" {
+		val := os.Getenv("MONITOR_BLOCKIO_MBPS")
+		valInt, err := strconv.Atoi(val)
+
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'MONITOR_BLOCKIO_MBPS' could not be parsed... value read: ", val)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.MonitorBlockIOMBps = valInt
+		}
+	}
+	if os.Getenv("MONITOR_NETWORK_MBPS") != "" {
+		val := os.Getenv("MONITOR_NETWORK_MBPS")
+		valInt, err := strconv.Atoi(val)
+
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'MONITOR_NETWORK_MBPS' could not be parsed... value read: ", val)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.MonitorNetworkMBps = valInt
+		}
+	}
+	if os.Getenv("MONITOR_STATS_WINDOW_SECONDS") != "" {
+		val := os.Getenv("MONITOR_STATS_WINDOW_SECONDS")
+		valInt, err := strconv.Atoi(val)
+
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'MONITOR_STATS_WINDOW_SECONDS' could not be parsed... value read: ", val)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.MonitorStatsWindowSeconds = valInt
+		}
+	}
 	if os.Getenv("RETENTION_HOURS") != "" {
 		val := os.Getenv("RETENTION_HOURS")
 		valInt, err := strconv.Atoi(val)
@@ -320,10 +848,187 @@ func (c *Config) LoadFromEnv() error {
 			c.ConcurrentBackupContainer = valInt
 		}
 	}
+	if os.Getenv("BACKUP_COMPRESSION_LEVEL") != "" {
+		val := os.Getenv("BACKUP_COMPRESSION_LEVEL")
+		valInt, err := strconv.Atoi(val)
+
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'BACKUP_COMPRESSION_LEVEL' could not be parsed... value read: ", val)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.BackupCompressionLevel = valInt
+		}
+	}
+	if os.Getenv("BACKUP_QUIESCE_TIMEOUT_SECONDS") != "" {
+		val := os.Getenv("BACKUP_QUIESCE_TIMEOUT_SECONDS")
+		valInt, err := strconv.Atoi(val)
+
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'BACKUP_QUIESCE_TIMEOUT_SECONDS' could not be parsed... value read: ", val)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.BackupQuiesceTimeoutSeconds = valInt
+		}
+	}
+	if os.Getenv("BACKUP_INCREMENTAL_FULL_EVERY") != "" {
+		val := os.Getenv("BACKUP_INCREMENTAL_FULL_EVERY")
+		valInt, err := strconv.Atoi(val)
+
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'BACKUP_INCREMENTAL_FULL_EVERY' could not be parsed... value read: ", val)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.BackupIncrementalFullEvery = valInt
+		}
+	}
+	if os.Getenv("S3_PART_SIZE_MB") != "" {
+		val := os.Getenv("S3_PART_SIZE_MB")
+		valInt, err := strconv.Atoi(val)
+
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'S3_PART_SIZE_MB' could not be parsed... value read: ", val)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.S3PartSizeMB = valInt
+		}
+	}
+	if os.Getenv("SFTP_PORT") != "" {
+		val := os.Getenv("SFTP_PORT")
+		valInt, err := strconv.Atoi(val)
+
+		if err != nil {
+			log.Debug(err)
+			log.Error("Environment Variable 'SFTP_PORT' could not be parsed... value read: ", val)
+			log.Warn("Falling back to value in 'config.json' or to default value!")
+		} else {
+			c.SFTPPort = valInt
+		}
+	}
 
 	return nil
 }
 
+func (c *Config) BackupEncryption() archive.Encryption {
+	return archive.Encryption{
+		Recipients:   c.BackupEncryptionRecipients,
+		Passphrase:   c.BackupEncryptionPassphrase,
+		IdentityFile: c.BackupEncryptionIdentityFile,
+	}
+}
+
+// Redacted returns a copy of the config with the secrets masked, to be logged.
+func (c Config) Redacted() Config {
+	if c.BackupEncryptionPassphrase != "" {
+		c.BackupEncryptionPassphrase = redacted
+	}
+	if c.S3SecretKey != "" {
+		c.S3SecretKey = redacted
+	}
+	// The targets share their S3 configs with Conf, they are copied before masking
+	targets := make([]BackupTarget, len(c.BackupTargets))
+	for i, t := range c.BackupTargets {
+		if t.S3 != nil && t.S3.SecretKey != "" {
+			s3 := *t.S3
+			s3.SecretKey = redacted
+			t.S3 = &s3
+		}
+		targets[i] = t
+	}
+	c.BackupTargets = targets
+	return c
+}
+
+// withoutEnvSecrets returns a copy of the config without the secrets set via environment variables, to be saved.
+// They are read from the environment on every start, so they never end up in the config file.
+func (c Config) withoutEnvSecrets() Config {
+	if os.Getenv("BACKUP_ENCRYPTION_PASSPHRASE") != "" {
+		c.BackupEncryptionPassphrase = ""
+	}
+	if os.Getenv("S3_SECRET_KEY") != "" {
+		c.S3SecretKey = ""
+	}
+	if os.Getenv("BACKUP_TARGETS") != "" {
+		// The targets share their S3 configs with Conf, they are copied before clearing
+		targets := make([]BackupTarget, len(c.BackupTargets))
+		for i, t := range c.BackupTargets {
+			if t.S3 != nil && t.S3.SecretKey != "" {
+				s3 := *t.S3
+				s3.SecretKey = ""
+				t.S3 = &s3
+			}
+			targets[i] = t
+		}
+		c.BackupTargets = targets
+	}
+	return c
+}
+
+// Schedules returns the global backup schedule, BackupSchedule and every hour of BackupHours as cron expression.
+func (c *Config) Schedules() []string {
+	schedules := []string{}
+	for _, spec := range append(append([]string{}, c.BackupSchedule...), HoursToSchedules(c.BackupHours)...) {
+		if !slices.Contains(schedules, spec) {
+			schedules = append(schedules, spec)
+		}
+	}
+	return schedules
+}
+
+// HoursToSchedules converts BackupHours to cron expressions, at minute 5 of every hour.
+func HoursToSchedules(hours []int) []string {
+	schedules := []string{}
+	for _, h := range hours {
+		schedules = append(schedules, "5 "+strconv.Itoa(h)+" * * *")
+	}
+	return schedules
+}
+
+// Retention returns the global retention policy, RetentionHours if no RetentionPolicy is set.
+func (c *Config) Retention() retention.Policy {
+	if c.RetentionPolicy != "" {
+		p, err := retention.ParsePolicy(c.RetentionPolicy)
+		if err == nil {
+			return p
+		}
+	}
+	return retention.Policy{Within: c.RetentionHours}
+}
+
+// Targets returns the BackupTargets, a single target built from StorageBackend if none are configured.
+func (c *Config) Targets() []BackupTarget {
+	if len(c.BackupTargets) != 0 {
+		return c.BackupTargets
+	}
+
+	return []BackupTarget{{
+		Name:    "default",
+		Backend: c.StorageBackend,
+		Path:    c.BackupPath,
+		S3: &storage.S3Config{
+			Endpoint:   c.S3Endpoint,
+			Bucket:     c.S3Bucket,
+			Prefix:     c.S3Prefix,
+			Region:     c.S3Region,
+			AccessKey:  c.S3AccessKey,
+			SecretKey:  c.S3SecretKey,
+			UseSSL:     c.S3UseSSL,
+			PartSizeMB: c.S3PartSizeMB,
+		},
+		SFTP: &storage.SFTPConfig{
+			Host:           c.SFTPHost,
+			Port:           c.SFTPPort,
+			User:           c.SFTPUser,
+			KeyFile:        c.SFTPKeyFile,
+			KnownHostsFile: c.SFTPKnownHostsFile,
+			Path:           c.SFTPPath,
+		},
+	}}
+}
+
 func (c *Config) SetVersion() {
 	if os.Getenv("VERSION") != "" {
 		c.Version = os.Getenv("VERSION")
@@ -362,7 +1067,7 @@ func (c *Config) Save() error {
 
 	Conf.SetVersion()
 
-	confFile, err := json.MarshalIndent(Conf, "", " ")
+	confFile, err := json.MarshalIndent(Conf.withoutEnvSecrets(), "", " ")
 	if err != nil {
 		return errors.New("Error marshalling config file: " + err.Error())
 	}
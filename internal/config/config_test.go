@@ -0,0 +1,107 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/bata94/DockerRight/internal/storage"
+)
+
+func TestRedacted(t *testing.T) {
+	tests := []struct {
+		name string
+		conf Config
+	}{
+		{"no secrets", Config{}},
+		{"passphrase", Config{BackupEncryptionPassphrase: "passphrase"}},
+		{"s3 secret key", Config{S3SecretKey: "secret"}},
+		{"target secret keys", Config{BackupTargets: []BackupTarget{
+			{Name: "local", Backend: "local", Path: "/backup"},
+			{Name: "s3", Backend: "s3", S3: &storage.S3Config{AccessKey: "access", SecretKey: "secret"}},
+		}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.conf.Redacted()
+
+			if want := redactedValue(tt.conf.BackupEncryptionPassphrase); got.BackupEncryptionPassphrase != want {
+				t.Errorf("BackupEncryptionPassphrase = %q, want %q", got.BackupEncryptionPassphrase, want)
+			}
+			if want := redactedValue(tt.conf.S3SecretKey); got.S3SecretKey != want {
+				t.Errorf("S3SecretKey = %q, want %q", got.S3SecretKey, want)
+			}
+			if len(got.BackupTargets) != len(tt.conf.BackupTargets) {
+				t.Fatalf("got %d BackupTargets, want %d", len(got.BackupTargets), len(tt.conf.BackupTargets))
+			}
+			for i, target := range tt.conf.BackupTargets {
+				if target.S3 == nil {
+					continue
+				}
+				if want := redactedValue(target.S3.SecretKey); got.BackupTargets[i].S3.SecretKey != want {
+					t.Errorf("BackupTargets[%d].S3.SecretKey = %q, want %q", i, got.BackupTargets[i].S3.SecretKey, want)
+				}
+				if got.BackupTargets[i].S3.AccessKey != target.S3.AccessKey {
+					t.Errorf("BackupTargets[%d].S3.AccessKey = %q, want %q", i, got.BackupTargets[i].S3.AccessKey, target.S3.AccessKey)
+				}
+				// The config in use must keep its secrets
+				if target.S3.SecretKey == redacted {
+					t.Errorf("BackupTargets[%d].S3.SecretKey of the original config was redacted", i)
+				}
+			}
+		})
+	}
+}
+
+// redactedValue is the expected value of a secret in the redacted config.
+func redactedValue(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+func TestWithoutEnvSecrets(t *testing.T) {
+	conf := Config{
+		BackupEncryptionPassphrase: "passphrase",
+		S3SecretKey:                "secret",
+		BackupTargets: []BackupTarget{
+			{Name: "s3", Backend: "s3", S3: &storage.S3Config{AccessKey: "access", SecretKey: "target-secret"}},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		env            map[string]string
+		wantPassphrase string
+		wantS3Secret   string
+		wantTargetKey  string
+	}{
+		{"no env", nil, "passphrase", "secret", "target-secret"},
+		{"passphrase from env", map[string]string{"BACKUP_ENCRYPTION_PASSPHRASE": "passphrase"}, "", "secret", "target-secret"},
+		{"s3 secret key from env", map[string]string{"S3_SECRET_KEY": "secret"}, "passphrase", "", "target-secret"},
+		{"targets from env", map[string]string{"BACKUP_TARGETS": "[]"}, "passphrase", "secret", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"BACKUP_ENCRYPTION_PASSPHRASE", "S3_SECRET_KEY", "BACKUP_TARGETS"} {
+				t.Setenv(k, tt.env[k])
+			}
+
+			got := conf.withoutEnvSecrets()
+			if got.BackupEncryptionPassphrase != tt.wantPassphrase {
+				t.Errorf("BackupEncryptionPassphrase = %q, want %q", got.BackupEncryptionPassphrase, tt.wantPassphrase)
+			}
+			if got.S3SecretKey != tt.wantS3Secret {
+				t.Errorf("S3SecretKey = %q, want %q", got.S3SecretKey, tt.wantS3Secret)
+			}
+			if got.BackupTargets[0].S3.SecretKey != tt.wantTargetKey {
+				t.Errorf("BackupTargets[0].S3.SecretKey = %q, want %q", got.BackupTargets[0].S3.SecretKey, tt.wantTargetKey)
+			}
+			// The config in use must keep its secrets
+			if conf.BackupTargets[0].S3.SecretKey != "target-secret" {
+				t.Errorf("BackupTargets[0].S3.SecretKey of the original config = %q", conf.BackupTargets[0].S3.SecretKey)
+			}
+		})
+	}
+}
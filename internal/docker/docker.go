@@ -1,7 +1,10 @@
 package docker
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -10,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bata94/DockerRight/internal/archive"
 	"github.com/bata94/DockerRight/internal/config"
 	"github.com/bata94/DockerRight/internal/log"
 	"github.com/bata94/DockerRight/internal/workpool"
@@ -20,12 +24,15 @@ import (
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 )
 
 var (
 	ctx      = context.Background()
 	cli      *client.Client
 	defImage = "debian:latest"
+	// ownContainerID is the ID of the container DockerRight runs in, empty if it runs outside of Docker.
+	ownContainerID string
 )
 
 func Init() {
@@ -39,6 +46,19 @@ func Init() {
 	}
 	defer cli.Close()
 
+	ownContainerID = findOwnContainerID()
+	if ownContainerID != "" {
+		log.Info("Running inside container ", ownContainerID)
+	} else {
+		log.Info("Running outside of a container")
+	}
+
+	err = initStorage()
+	if err != nil {
+		log.Error("Error initializing storage backend: ")
+		log.Fatal(err)
+	}
+
 	reader, err := cli.ImagePull(ctx, defImage, image.PullOptions{})
 	if err != nil {
 		log.Error("Error pulling image: ")
@@ -67,6 +87,57 @@ func Init() {
 	log.Info("Docker initialized")
 }
 
+// findOwnContainerID looks for the containers directory of the Docker daemon in the own mounts
+// (resolv.conf, hostname and hosts are mounted from there), falling back to the hostname.
+func findOwnContainerID() string {
+	mountInfo, err := os.ReadFile("/proc/self/mountinfo")
+	if err == nil {
+		for _, line := range strings.Split(string(mountInfo), "\n") {
+			i := strings.Index(line, "/containers/")
+			if i == -1 {
+				continue
+			}
+			id := line[i+len("/containers/"):]
+			if j := strings.Index(id, "/"); j != -1 {
+				id = id[:j]
+			}
+			if _, err := hex.DecodeString(id); len(id) == 64 && err == nil {
+				return id
+			}
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || len(hostname) < 12 {
+		return ""
+	}
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return ""
+	}
+	for _, ctr := range containers {
+		if strings.HasPrefix(ctr.ID, hostname) {
+			return ctr.ID
+		}
+	}
+
+	return ""
+}
+
+// skipContainer reports if a container must not be backed up, which are DockerRight itself,
+// its helper containers and other DockerRight instances.
+func skipContainer(ctr types.Container) bool {
+	if ownContainerID != "" && ctr.ID == ownContainerID {
+		return true
+	}
+	for _, containerName := range ctr.Names {
+		if strings.Contains(strings.ToLower(containerName), "dockerright") {
+			return true
+		}
+	}
+	return false
+}
+
 func PullImage(imageName string) error {
 	log.Debug("Check if Image needs to be pulled")
 	images, err := cli.ImageList(ctx, image.ListOptions{})
@@ -125,16 +196,32 @@ type RunContainerParams struct {
 	Volumes       map[string]struct{}
 	VolumesFrom   []string
 	Mounts        []mount.Mount
+	// Stdin is streamed into the container, Stdout receives the stdout of the container.
+	// If Stdout is set, only stderr is returned as logs.
+	Stdin  io.Reader
+	Stdout io.Writer
+	// CopyIn files (absolute path -> content) are copied into the container before it is started,
+	// CopyOut files are read from the container after it exited.
+	CopyIn  map[string][]byte
+	CopyOut map[string]io.Writer
 }
 
 func RunContainer(p RunContainerParams) ([]byte, error) {
+	logs, _, err := RunContainerWithStatus(p)
+	return logs, err
+}
+
+// RunContainerWithStatus runs a container like RunContainer and additionally returns its exit code.
+func RunContainerWithStatus(p RunContainerParams) ([]byte, int64, error) {
 	log.Debug("Running Container")
 
 	err := PullImage(p.ImageName)
 	if err != nil {
-		log.Fatal(err)
+		log.Error(err)
+		return nil, -1, err
 	}
 
+	attach := p.Stdin != nil || p.Stdout != nil
 	ctr, err := cli.ContainerCreate(
 		ctx,
 		&container.Config{
@@ -143,6 +230,11 @@ func RunContainer(p RunContainerParams) ([]byte, error) {
 			Cmd:             p.Cmd,
 			NetworkDisabled: true,
 			Volumes:         p.Volumes,
+			AttachStdin:     p.Stdin != nil,
+			AttachStdout:    attach,
+			AttachStderr:    attach,
+			OpenStdin:       p.Stdin != nil,
+			StdinOnce:       p.Stdin != nil,
 		},
 		&container.HostConfig{
 			VolumesFrom: p.VolumesFrom,
@@ -156,7 +248,34 @@ func RunContainer(p RunContainerParams) ([]byte, error) {
 		log.Error("Error creating container: ")
 		log.Error(err)
 		_ = RemoveContainer(ctr.ID)
-		return nil, err
+		return nil, -1, err
+	}
+
+	if len(p.CopyIn) != 0 {
+		err = copyToContainer(ctr.ID, p.CopyIn)
+		if err != nil {
+			log.Error("Error copying files into container: ")
+			log.Error(err)
+			_ = RemoveContainer(ctr.ID)
+			return nil, -1, err
+		}
+	}
+
+	var hijacked types.HijackedResponse
+	if attach {
+		hijacked, err = cli.ContainerAttach(ctx, ctr.ID, container.AttachOptions{
+			Stream: true,
+			Stdin:  p.Stdin != nil,
+			Stdout: true,
+			Stderr: true,
+		})
+		if err != nil {
+			log.Error("Error attaching to container: ")
+			log.Error(err)
+			_ = RemoveContainer(ctr.ID)
+			return nil, -1, err
+		}
+		defer hijacked.Close()
 	}
 
 	err = cli.ContainerStart(ctx, ctr.ID, container.StartOptions{})
@@ -164,9 +283,21 @@ func RunContainer(p RunContainerParams) ([]byte, error) {
 		log.Error("Error starting container: ")
 		log.Error(err)
 		_ = RemoveContainer(ctr.ID)
-		return nil, err
+		return nil, -1, err
+	}
+
+	var logs []byte
+	if attach {
+		logs, err = streamContainer(hijacked, p.Stdin, p.Stdout)
+		if err != nil {
+			log.Error("Error streaming container: ")
+			log.Error(err)
+			_ = RemoveContainer(ctr.ID)
+			return nil, -1, err
+		}
 	}
 
+	var exitCode int64
 	statusCh, errCh := cli.ContainerWait(ctx, ctr.ID, container.WaitConditionNotRunning)
 	select {
 	case err := <-errCh:
@@ -174,104 +305,155 @@ func RunContainer(p RunContainerParams) ([]byte, error) {
 			log.Error(err)
 			log.Error(err)
 			_ = RemoveContainer(ctr.ID)
-			return nil, err
+			return nil, -1, err
 		}
-	case <-statusCh:
-		log.Debug("Container finished!")
+	case status := <-statusCh:
+		log.Debug("Container finished! ExitCode: ", status.StatusCode)
+		exitCode = status.StatusCode
 	}
 
-	out, err := cli.ContainerLogs(ctx, ctr.ID, container.LogsOptions{
-		ShowStdout: true,
-		ShowStderr: true,
-		Follow:     true,
-	})
-	if err != nil {
-		log.Error("Error getting logs: ", err)
-	}
-	defer out.Close()
-	if err != nil {
-		log.Error(err)
-		_ = RemoveContainer(ctr.ID)
-		return nil, err
-	}
+	if !attach {
+		out, err := cli.ContainerLogs(ctx, ctr.ID, container.LogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+			Follow:     true,
+		})
+		if err != nil {
+			log.Error("Error getting logs: ", err)
+			_ = RemoveContainer(ctr.ID)
+			return nil, -1, err
+		}
+		defer out.Close()
 
-	logs, err := io.ReadAll(out)
-	if err != nil {
-		log.Error(err)
-		_ = RemoveContainer(ctr.ID)
-		return nil, err
+		logs, err = io.ReadAll(out)
+		if err != nil {
+			log.Error(err)
+			_ = RemoveContainer(ctr.ID)
+			return nil, -1, err
+		}
 	}
 
 	log.Debug("Container output:", "\n", string(logs))
 
+	for path, w := range p.CopyOut {
+		err = copyFromContainer(ctr.ID, path, w)
+		if err != nil {
+			log.Error("Error copying ", path, " out of container: ")
+			log.Error(err)
+			_ = RemoveContainer(ctr.ID)
+			return nil, -1, err
+		}
+	}
+
 	if p.Remove {
 		err = RemoveContainer(ctr.ID)
 		if err != nil {
-			log.Fatal(err)
+			log.Error(err)
 		}
 	}
 
-	return logs, nil
+	return logs, exitCode, nil
 }
 
-type ContainerInfo struct {
-	ID           string
-	Name         string
-	States       []string
-	MonitorState string
+func copyToContainer(containerID string, files map[string][]byte) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for path, content := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    strings.TrimPrefix(path, "/"),
+			Mode:    0o600,
+			Size:    int64(len(content)),
+			ModTime: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(content)
+		if err != nil {
+			return err
+		}
+	}
+	err := tw.Close()
+	if err != nil {
+		return err
+	}
+
+	return cli.CopyToContainer(ctx, containerID, "/", &buf, types.CopyToContainerOptions{})
 }
 
-func MonitorContainers(contInfos *[]ContainerInfo) error {
-	log.Info("MonitorContainers")
+func copyFromContainer(containerID, path string, w io.Writer) error {
+	rc, _, err := cli.CopyFromContainer(ctx, containerID, path)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
 
-	c, err := cli.ContainerList(ctx, container.ListOptions{
-		All: true,
-	})
+	tr := tar.NewReader(rc)
+	_, err = tr.Next()
 	if err != nil {
 		return err
 	}
+	_, err = io.Copy(w, tr)
+	return err
+}
 
-	for _, container := range c {
-		inList := false
-		contState := container.State
-		if strings.Contains(container.Status, "unhealthy") {
-			contState = "unhealthy"
-		}
+// ExecContainer runs cmd inside a running container, streaming stdin into and stdout out of it.
+// It returns stderr (and stdout if stdout is nil) and the exit code of cmd.
+func ExecContainer(containerID string, cmd []string, stdin io.Reader, stdout io.Writer) ([]byte, int64, error) {
+	log.Debug("Exec in Container ", containerID, " ", cmd)
 
-		for i, ci := range *contInfos {
-			if container.ID == ci.ID {
-				inList = true
-				(*contInfos)[i].States = append(ci.States, contState)
-				break
-			}
-		}
+	exec, err := cli.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		Cmd:          cmd,
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, -1, errors.New("Error creating exec: " + err.Error())
+	}
 
-		if !inList {
-			*contInfos = append(*contInfos, ContainerInfo{ID: container.ID, Name: container.Names[0][1:], States: []string{contState}})
-		}
+	hijacked, err := cli.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{})
+	if err != nil {
+		return nil, -1, errors.New("Error attaching to exec: " + err.Error())
 	}
+	defer hijacked.Close()
 
-	return nil
-}
+	logs, err := streamContainer(hijacked, stdin, stdout)
+	if err != nil {
+		return logs, -1, errors.New("Error streaming exec: " + err.Error())
+	}
 
-func GetHostBackupPath(containers []types.Container) string {
-	hostBackupPath := ""
+	inspect, err := cli.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return logs, -1, errors.New("Error inspecting exec: " + err.Error())
+	}
 
-	for _, ctr := range containers {
-		log.Debug(ctr.Names)
-		if strings.Contains(strings.ToLower(ctr.Names[0]), "dockerright") {
-			for _, m := range ctr.Mounts {
-				log.Debug(m)
-				log.Debug("BackupPathConf: " + config.Conf.BackupPath)
-				log.Debug("MountDestination: " + m.Destination)
-				if strings.EqualFold(m.Destination, config.Conf.BackupPath) {
-					hostBackupPath = m.Source
-				}
+	return logs, int64(inspect.ExitCode), nil
+}
+
+// streamContainer copies stdin into an attached container and demultiplexes its output,
+// until the container closes its output streams.
+func streamContainer(hijacked types.HijackedResponse, stdin io.Reader, stdout io.Writer) ([]byte, error) {
+	if stdin != nil {
+		go func() {
+			_, err := io.Copy(hijacked.Conn, stdin)
+			if err != nil {
+				log.Error("Error streaming into container: ", err)
 			}
-		}
+			err = hijacked.CloseWrite()
+			if err != nil {
+				log.Error("Error closing container stdin: ", err)
+			}
+		}()
+	}
+
+	var logs bytes.Buffer
+	if stdout == nil {
+		stdout = &logs
 	}
+	_, err := stdcopy.StdCopy(stdout, &logs, hijacked.Reader)
 
-	return hostBackupPath
+	return logs.Bytes(), err
 }
 
 func RunOSCmd(cmdType, cmd string) ([]byte, error) {
@@ -300,23 +482,48 @@ func RunOSCmd(cmdType, cmd string) ([]byte, error) {
 	}
 }
 
+// BackupContainers backs up every container, which hasn't disabled backups via label.
 func BackupContainers() error {
 	log.Info("BackupContainers")
+	return backupContainers("all containers", func(types.Container, ContainerSettings) bool { return true })
+}
 
-	containers, err := cli.ContainerList(context.Background(), container.ListOptions{All: true})
+// backupContainers backs up all due containers in a single run, group names the run for the run lock.
+func backupContainers(group string, due func(types.Container, ContainerSettings) bool) error {
+	unlock, err := lockRun(group)
 	if err != nil {
-		log.Error("Error listing containers: ")
 		log.Error(err)
 		return err
 	}
+	defer unlock()
 
-	hostBackupPath := GetHostBackupPath(containers)
-	if hostBackupPath == "" {
-		err := errors.New("Error finding backup path! Maybe you changed the Dockerright Container name to something else then 'dockerright'?")
+	allContainers, err := cli.ContainerList(context.Background(), container.ListOptions{All: true})
+	if err != nil {
+		log.Error("Error listing containers: ")
 		log.Error(err)
 		return err
 	}
 
+	containers := []types.Container{}
+	for _, ctr := range allContainers {
+		if skipContainer(ctr) {
+			continue
+		}
+		settings := GetContainerSettings(ctr.Names[0], ctr.Labels)
+		if !settings.BackupEnable {
+			log.Debug("Backup disabled via label for container ", ctr.Names[0])
+			continue
+		}
+		if !due(ctr, settings) {
+			continue
+		}
+		containers = append(containers, ctr)
+	}
+	if len(containers) == 0 {
+		log.Info("No containers to backup")
+		return nil
+	}
+
 	log.Info("Running BeforeBackupCMD", "\n", config.Conf.BeforeBackupCMD)
 	output, err := RunOSCmd("BeforeBackupCMD", config.Conf.BeforeBackupCMD)
 	if err != nil {
@@ -325,28 +532,19 @@ func BackupContainers() error {
 		log.Info("BeforeBackupCMD ran successfully, Output:", "\n", string(output))
 	}
 
+	run := newBackupRun(containers)
+	retryStoreSnapshots(run)
 	var wg workpool.WaitGroupCount
 	for _, ctr := range containers {
-		// Skip dockerright named containers
-		skip := false
-		for _, containerName := range ctr.Names {
-			if strings.Contains(strings.ToLower(containerName), "dockerright") {
-				skip = true
-				continue
-			}
-		}
-		if skip {
-			continue
-		}
-
 		wg.Add(1)
 		log.Info("Current concurrent BackupRunners: ", wg.GetCount())
-		for wg.GetCount() > config.Conf.ConcurrentBackupContainer {
+		// Containers waiting for the archive of a shared mount don't block the others, their owners among them
+		for wg.GetCount()-run.Waiting() > config.Conf.ConcurrentBackupContainer {
 			time.Sleep(time.Duration(time.Millisecond * 250))
 		}
 		go func(ctr types.Container) {
 			defer wg.Done()
-			backupErr := RunBackupHelperForContainer(ctr, hostBackupPath)
+			backupErr := runBackupHelper(ctr, run)
 
 			if backupErr != nil {
 				log.Error("Error in concurrent backup runner ", ctr.Names[0], " Error: ", backupErr)
@@ -354,6 +552,7 @@ func BackupContainers() error {
 		}(ctr)
 	}
 	wg.Wait()
+	run.logSummary()
 
 	log.Info("Running AfterBackupCMD", "\n", config.Conf.AfterBackupCMD)
 	output, err = RunOSCmd("AfterBackupCMD", config.Conf.AfterBackupCMD)
@@ -372,17 +571,31 @@ func BackupContainers() error {
 	return nil
 }
 
-func RunBackupHelperForContainer(container types.Container, hostBackupPath string) error {
+func RunBackupHelperForContainer(container types.Container) error {
+	return runBackupHelper(container, newBackupRun([]types.Container{container}))
+}
+
+func runBackupHelper(container types.Container, run *backupRun) error {
 	log.Info("RunBackupHelperForContainer" + container.Names[0])
 	log.Info(fmt.Sprintf("%s %s %s (status: %s)\n", container.ID, container.Names, container.Image, container.Status))
 
-	if container.Mounts == nil || len(container.Mounts) == 0 {
+	settings := GetContainerSettings(container.Names[0], container.Labels)
+	dumpStrategy := GetDumpStrategy(container, settings)
+	if dumpStrategy != nil && container.State != "running" {
+		log.Warn("Container ", container.Names[0], " is not running, skipping ", dumpStrategy.Name, " dump")
+		dumpStrategy = nil
+	}
+
+	if (container.Mounts == nil || len(container.Mounts) == 0) && dumpStrategy == nil {
 		log.Info("Container has no mounts")
 		return nil
 	}
 
 	containerNameBase := "DockerRight-BackupRunner-" + strings.ReplaceAll(container.Names[0], "/", "")
-	now := time.Now()
+	now := run.Timestamp
+
+	// Containers sharing a mount with this one must not wait forever, if it fails before archiving it
+	defer run.releaseOwned(strings.ReplaceAll(container.Names[0], "/", ""))
 
 	backupPathBase := config.Conf.BackupPath
 	if !strings.HasSuffix(backupPathBase, "/") {
@@ -400,41 +613,93 @@ func RunBackupHelperForContainer(container types.Container, hostBackupPath strin
 		log.Error("Unable to save ContainerInfoFile for container ", container.Names[0], " Error: ", err)
 	}
 
+	manifest := Manifest{
+		Container:   strings.ReplaceAll(container.Names[0], "/", ""),
+		ContainerID: container.ID,
+		Image:       container.Image,
+		Snapshot:    now.Format("2006-01-02-15-04-05"),
+		Created:     now,
+		Compression: config.Conf.BackupCompression,
+		Encrypted:   config.Conf.BackupEncryption().Enabled(),
+		Mounts:      []ManifestMount{},
+	}
+	// Mounts archived by another container, by their index in manifest.Mounts
+	sharedMounts := map[int]types.MountPoint{}
+	defer func() {
+		// Released first, two containers might share mounts with each other
+		run.releaseOwned(manifest.Container)
+		for i, m := range sharedMounts {
+			err := run.waitShared(m)
+			if err != nil {
+				log.Error("Shared archive of mount ", m.Destination, " for container ", container.Names[0], " failed: ", err)
+				manifest.Mounts[i].Error = "Shared archive failed: " + err.Error()
+			}
+		}
+
+		err := WriteManifest(backupPathBase+backupPath, manifest)
+		if err != nil {
+			log.Error("Unable to save manifest for container ", container.Names[0], " Error: ", err)
+		}
+		run.recordStored(manifest.Container, storeSnapshot(manifest.Container, manifest.Snapshot))
+	}()
+
+	var dumpErr error
+	if dumpStrategy != nil {
+		manifestDump, out, err := backupDump(container, dumpStrategy, backupPathBase+backupPath)
+		manifest.Dump = &manifestDump
+		if err != nil {
+			log.Error("Error dumping database of container ", container.Names[0], " Error: ", err)
+			dumpErr = err
+		}
+
+		err = os.WriteFile(backupPathBase+backupPath+dumpFileName(dumpStrategy)+".log", out, 0o644)
+		if err != nil {
+			log.Error("Unable to save dump logfile for container ", container.Names[0], " Error: ", err)
+		}
+	}
+
+	resume, err := quiesceContainer(container, settings.BackupQuiesce)
+	if err != nil {
+		log.Error("Error quiescing container ", container.Names[0], " Error: ", err)
+		return err
+	}
+	defer resume()
+
 	for i, m := range container.Mounts {
 		containerName := fmt.Sprint(containerNameBase, "-m", i, "-", strings.ReplaceAll(m.Destination, "/", "_"))
 		log.Info(fmt.Sprintf("Creating container %s", containerName))
 
-		// TODO: Move those to a Parameter
-		if strings.HasSuffix(m.Destination, ".sock") || strings.HasSuffix(m.Source, ".sock") {
-			log.Warn(fmt.Sprintf("Skipping mount %s : %s for Container %s because it contains a socket!", m.Source, m.Destination, containerName))
-			continue
-		} else if m.Source == "/" {
-			log.Warn(fmt.Sprintf("Skipping mount %s : %s for Container %s because it is the root directory!", m.Source, m.Destination, containerName))
+		if reason := mountSkipReason(settings, m); reason != "" {
+			msg := fmt.Sprintf("Skipping mount %s : %s for Container %s because %s!", m.Source, m.Destination, containerName, reason)
+			if settings.MountExcluded(m.Destination) {
+				log.Info(msg)
+			} else {
+				log.Warn(msg)
+			}
 			continue
-		} else if strings.Contains(m.Destination, "/var/lib/docker/volumes") {
-			log.Warn(fmt.Sprintf("Skipping mount %s : %s for Container %s because it is /var/lib/docker/volumes!", m.Source, m.Destination, containerName))
+		}
+
+		if owner, ok := run.sharedOwner(manifest.Container, m); ok {
+			sharedArchive := owner.Container + "/" + run.Snapshot() + "/" + mountArchiveName(owner.Mount) + archiveExtension()
+			log.Info("Mount ", m.Destination, " of container ", container.Names[0], " is shared with ", owner.Container, ", archived in ", sharedArchive)
+			sharedMounts[len(manifest.Mounts)] = m
+			manifest.Mounts = append(manifest.Mounts, ManifestMount{
+				Type:          string(m.Type),
+				Source:        m.Source,
+				Destination:   m.Destination,
+				SharedArchive: sharedArchive,
+				TarExitCode:   -1,
+			})
 			continue
 		}
 
-		mountInfoFileName := fmt.Sprint(m.Type) + strings.Replace(m.Destination, "/", "_", -1)
-		// backupPathBase := strings.ReplaceAll(container.Names[0], "/", "") + "/" + now.Format("2006-01-02-15-04-05") + "/" + mountInfoFileName
+		mountInfoFileName := mountArchiveName(m)
+		archivePath := backupPathBase + backupPath + mountInfoFileName + archiveExtension()
 
-		cmd := []string{"tar", "cvf", backupPathBase + "/" + backupPath + mountInfoFileName + ".tar", m.Destination}
-		log.Debug(cmd)
-		out, err := RunContainer(RunContainerParams{
-			ContainerName: containerName,
-			ImageName:     defImage,
-			Cmd:           cmd,
-			Remove:        true,
-			VolumesFrom:   []string{container.ID},
-			Mounts: []mount.Mount{
-				{
-					Type:   mount.TypeBind,
-					Source: hostBackupPath,
-					Target: backupPathBase,
-				},
-			},
-		})
+		manifestMount, out, err := backupMount(container, containerName, m, archivePath, run.Snapshot())
+		manifestMount.SharedWith = run.sharedWith(manifest.Container, m)
+		manifest.Mounts = append(manifest.Mounts, manifestMount)
+		run.archivedShared(manifest.Container, m, err)
 		if err != nil {
 			return err
 		}
@@ -444,53 +709,202 @@ func RunBackupHelperForContainer(container types.Container, hostBackupPath strin
 			log.Error("Unable to save backup logfile for container ", containerName, " Error: ", err)
 		}
 	}
+	resume()
 	time.Sleep(time.Second * 5)
 
-	return nil
+	return dumpErr
 }
 
-func DeleteOldBackups() error {
-	log.Info("DeleteOldBackups")
+// mountSkipReason returns why a mount is not backed up, empty if it is.
+// TODO: Move those to a Parameter
+func mountSkipReason(settings ContainerSettings, m types.MountPoint) string {
+	if settings.MountExcluded(m.Destination) {
+		return "it is excluded via label"
+	} else if m.Type != mount.TypeVolume && m.Type != mount.TypeBind {
+		return "its type " + string(m.Type) + " is not supported"
+	} else if strings.HasSuffix(m.Destination, ".sock") || strings.HasSuffix(m.Source, ".sock") {
+		return "it contains a socket"
+	} else if m.Source == "/" {
+		return "it is the root directory"
+	} else if strings.Contains(m.Destination, "/var/lib/docker/volumes") {
+		return "it is /var/lib/docker/volumes"
+	}
+	return ""
+}
 
-	containerDirs, err := os.ReadDir(config.Conf.BackupPath)
+// archiveExtension returns the extension of mount archives with the configured compression and encryption.
+func archiveExtension() string {
+	ext := archive.Extension(config.Conf.BackupCompression)
+	if config.Conf.BackupEncryption().Enabled() {
+		ext += archive.EncryptionExtension
+	}
+	return ext
+}
+
+// backupMount streams a tarball of the mount out of a helper container and writes it (compressed and encrypted) to archivePath.
+// The plaintext archive only exists in memory.
+func backupMount(container types.Container, containerName string, m types.MountPoint, archivePath, snapshot string) (ManifestMount, []byte, error) {
+	manifestMount := ManifestMount{
+		Type:        string(m.Type),
+		Source:      m.Source,
+		Destination: m.Destination,
+		Archive:     archivePath[strings.LastIndex(archivePath, "/")+1:],
+		TarExitCode: -1,
+	}
+	start := time.Now()
+
+	out, err := writeMountArchive(container, containerName, m, archivePath, snapshot, &manifestMount)
+	manifestMount.DurationSeconds = time.Since(start).Seconds()
+	// tar exits with 1 if files changed while reading them, the archive is still usable. Above 1 it is not.
+	if err == nil && manifestMount.TarExitCode > 1 {
+		err = fmt.Errorf("tar exited with code %d for mount %s of container %s", manifestMount.TarExitCode, m.Destination, container.Names[0])
+	}
 	if err != nil {
-		log.Error("Error reading backup path: ", err)
-		return err
+		manifestMount.Error = err.Error()
+	} else if manifestMount.TarExitCode != 0 {
+		log.Warn("tar exited with code ", manifestMount.TarExitCode, " for mount ", m.Destination, " of container ", container.Names[0])
 	}
 
-	log.Info("Found ", len(containerDirs), " containerDirs:", "\n", containerDirs)
+	return manifestMount, out, err
+}
 
-	for _, c := range containerDirs {
-		if c.IsDir() {
-			log.Info("ContainerDir: ", c.Name())
-			backupDirs, err := os.ReadDir(config.Conf.BackupPath + "/" + c.Name())
-			if err != nil {
-				log.Error("Error reading backup path: ", err)
-				continue
-			}
-			log.Info("Found ", len(backupDirs), " backupDirs:", "\n", backupDirs)
-			for _, b := range backupDirs {
-				if b.IsDir() {
-					log.Info("BackupDir: ", b.Name())
-					backupTime, err := time.Parse("2006-01-02-15-04-05", b.Name())
-					if err != nil {
-						log.Error("Error parsing backup time: ", err)
-						continue
-					}
-					timeSinceBackup := time.Since(backupTime).Hours()
-					log.Info("timeSinceBackup: ", timeSinceBackup)
-					if timeSinceBackup > float64(config.Conf.RetentionHours) {
-						log.Info("Removing ", config.Conf.BackupPath+"/"+c.Name()+"/"+b.Name())
-						err = os.RemoveAll(config.Conf.BackupPath + "/" + c.Name() + "/" + b.Name())
-						if err != nil {
-							log.Error("Error removing backup: ", err)
-							continue
-						}
-					}
-				}
-			}
+func writeMountArchive(container types.Container, containerName string, m types.MountPoint, archivePath, snapshot string, manifestMount *ManifestMount) ([]byte, error) {
+	roMount, err := readOnlyMount(m)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := []string{"tar", "cvf", "-", m.Destination}
+	copyIn := map[string][]byte{}
+	copyOut := map[string]io.Writer{}
+	var snar bytes.Buffer
+	if config.Conf.BackupIncremental {
+		level, base, baseSnar := planIncremental(strings.TrimPrefix(container.Names[0], "/"), m)
+		manifestMount.Incremental = true
+		manifestMount.Level = level
+		manifestMount.BaseSnapshot = base
+		log.Info("Incremental backup of ", m.Destination, " level ", level, " base: ", base)
+
+		// Every backup runs in a new helper container, so device numbers of the mount are not stable
+		cmd = []string{"tar", "--listed-incremental=" + snarPath, "--no-check-device", "-cvf", "-", m.Destination}
+		if baseSnar != nil {
+			copyIn[snarPath] = baseSnar
 		}
+		copyOut[snarPath] = &snar
 	}
 
-	return nil
+	log.Debug(cmd)
+	res, err := writeArchive(archivePath, func(w io.Writer) ([]byte, int64, error) {
+		return RunContainerWithStatus(RunContainerParams{
+			ContainerName: containerName,
+			ImageName:     defImage,
+			Cmd:           cmd,
+			Remove:        true,
+			Mounts:        []mount.Mount{roMount},
+			Stdout:        w,
+			CopyIn:        copyIn,
+			CopyOut:       copyOut,
+		})
+	})
+	manifestMount.TarExitCode = res.ExitCode
+	manifestMount.Size = res.Size
+	manifestMount.SHA256 = res.SHA256
+
+	// tar exits with 1 if files changed while reading them, the archive is still usable
+	if err == nil && config.Conf.BackupIncremental && res.ExitCode <= 1 {
+		err := saveIncrementalState(strings.TrimPrefix(container.Names[0], "/"), m, snapshot, manifestMount.Level, snar.Bytes())
+		if err != nil {
+			log.Error("Error saving incremental state of ", m.Destination, ", next backup will be a full backup: ", err)
+			resetIncrementalState(strings.TrimPrefix(container.Names[0], "/"), m)
+		}
+	}
+
+	return res.Logs, err
+}
+
+// readOnlyMount mounts the same volume or bind as m read only, so the backup helper can't modify the data it backs up.
+func readOnlyMount(m types.MountPoint) (mount.Mount, error) {
+	switch m.Type {
+	case mount.TypeVolume:
+		return mount.Mount{
+			Type:     mount.TypeVolume,
+			Source:   m.Name,
+			Target:   m.Destination,
+			ReadOnly: true,
+		}, nil
+	case mount.TypeBind:
+		return mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   m.Source,
+			Target:   m.Destination,
+			ReadOnly: true,
+		}, nil
+	default:
+		return mount.Mount{}, errors.New("Mount type " + string(m.Type) + " of " + m.Destination + " can't be backed up")
+	}
+}
+
+type archiveResult struct {
+	Logs     []byte
+	ExitCode int64
+	Size     int64
+	SHA256   string
+}
+
+// writeArchive writes everything run streams into its writer (compressed and encrypted) to archivePath.
+// Incomplete archives are removed.
+func writeArchive(archivePath string, run func(w io.Writer) ([]byte, int64, error)) (archiveResult, error) {
+	res := archiveResult{ExitCode: -1}
+
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return res, err
+	}
+	defer f.Close()
+	hw := newHashWriter(f)
+
+	enc, err := archive.NewEncryptWriter(hw, config.Conf.BackupEncryption())
+	if err != nil {
+		_ = os.Remove(archivePath)
+		return res, err
+	}
+
+	w, err := archive.NewWriter(enc, config.Conf.BackupCompression, config.Conf.BackupCompressionLevel)
+	if err != nil {
+		_ = os.Remove(archivePath)
+		return res, err
+	}
+
+	res.Logs, res.ExitCode, err = run(w)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = enc.Close()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		log.Error("Removing incomplete archive ", archivePath)
+		_ = os.Remove(archivePath)
+		return res, err
+	}
+
+	res.Size = hw.size
+	res.SHA256 = hw.SHA256()
+
+	return res, nil
+}
+
+// mountArchiveName returns the file name (without extension) of a mount archive inside a snapshot.
+func mountArchiveName(m types.MountPoint) string {
+	return fmt.Sprint(m.Type) + strings.Replace(m.Destination, "/", "_", -1)
+}
+
+func DeleteOldBackups() error {
+	log.Info("DeleteOldBackups")
+
+	_, err := PruneBackups(false)
+	return err
 }
@@ -0,0 +1,263 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bata94/DockerRight/internal/archive"
+	"github.com/bata94/DockerRight/internal/config"
+	"github.com/bata94/DockerRight/internal/log"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// DumpStrategy dumps a database with its native tool via docker exec, instead of relying on a tarball of a live volume.
+// The commands use the environment variables of the official images for credentials.
+type DumpStrategy struct {
+	Name string
+	// Images are matched against the last path element of the image name, without tag.
+	Images    []string
+	Extension string
+	// DumpCmd writes the dump to stdout.
+	DumpCmd []string
+	// RestoreCmd reads the dump from stdin, while the container is running.
+	RestoreCmd []string
+	// RestoreFile is used instead of RestoreCmd, the dump is written to this path with a helper container,
+	// while the container is stopped.
+	RestoreFile string
+	// RestoreCheckCmd runs in the running container before the restore, a non-zero exit refuses the restore
+	// with its output as reason.
+	RestoreCheckCmd []string
+}
+
+// mysqlAuth sets the arguments ("$@") to the root user and its password, read from the variable or the *_FILE secret.
+// Without a password (i.e. MARIADB_ALLOW_EMPTY_ROOT_PASSWORD) no -p is passed, which would prompt for it.
+const mysqlAuth = `PW="${MARIADB_ROOT_PASSWORD:-$MYSQL_ROOT_PASSWORD}"; PW_FILE="${MARIADB_ROOT_PASSWORD_FILE:-$MYSQL_ROOT_PASSWORD_FILE}"; ` +
+	`if [ -z "$PW" ] && [ -n "$PW_FILE" ]; then PW=$(cat "$PW_FILE"); fi; ` +
+	`set -- -uroot; if [ -n "$PW" ]; then set -- "$@" -p"$PW"; fi; `
+
+var DumpStrategies = []DumpStrategy{
+	{
+		Name:      "postgres",
+		Images:    []string{"postgres", "postgresql", "postgis", "timescaledb"},
+		Extension: ".sql",
+		DumpCmd:   []string{"sh", "-c", `exec pg_dumpall --clean --if-exists -U "${POSTGRES_USER:-postgres}"`},
+		// pg_dumpall always recreates the connected user and drops roles still owning objects (--clean), both fail as expected.
+		// Every other error fails the restore, after psql ran the whole dump.
+		RestoreCmd: []string{"sh", "-c", `OUT=$(psql -q -v ON_ERROR_STOP=0 -U "${POSTGRES_USER:-postgres}" -d postgres 2>&1 >/dev/null); EXIT=$?; ` +
+			`printf '%s\n' "$OUT"; ` +
+			`ERRORS=$(printf '%s\n' "$OUT" | grep 'ERROR:' | grep -v -e 'role ".*" already exists' -e 'current user cannot be dropped' ` +
+			`-e 'role ".*" cannot be dropped because some objects depend on it'); ` +
+			`if [ -n "$ERRORS" ]; then printf 'Unexpected errors:\n%s\n' "$ERRORS"; exit 1; fi; exit $EXIT`},
+	},
+	{
+		Name:      "mysql",
+		Images:    []string{"mysql", "mariadb", "percona"},
+		Extension: ".sql",
+		DumpCmd: []string{"sh", "-c", mysqlAuth + `DUMP=$(command -v mariadb-dump || command -v mysqldump); ` +
+			`exec $DUMP --all-databases --single-transaction --routines --events "$@"`},
+		RestoreCmd: []string{"sh", "-c", mysqlAuth + `CLIENT=$(command -v mariadb || command -v mysql); ` +
+			`exec $CLIENT "$@"`},
+	},
+	{
+		Name:      "mongo",
+		Images:    []string{"mongo", "mongodb"},
+		Extension: ".archive",
+		DumpCmd: []string{"sh", "-c", `if [ -n "$MONGO_INITDB_ROOT_USERNAME" ]; then ` +
+			`exec mongodump --archive -u "$MONGO_INITDB_ROOT_USERNAME" -p "$MONGO_INITDB_ROOT_PASSWORD" --authenticationDatabase admin; ` +
+			`else exec mongodump --archive; fi`},
+		RestoreCmd: []string{"sh", "-c", `if [ -n "$MONGO_INITDB_ROOT_USERNAME" ]; then ` +
+			`exec mongorestore --archive --drop -u "$MONGO_INITDB_ROOT_USERNAME" -p "$MONGO_INITDB_ROOT_PASSWORD" --authenticationDatabase admin; ` +
+			`else exec mongorestore --archive --drop; fi`},
+	},
+	{
+		Name:        "redis",
+		Images:      []string{"redis", "valkey"},
+		Extension:   ".rdb",
+		DumpCmd:     []string{"sh", "-c", `exec redis-cli ${REDIS_PASSWORD:+-a "$REDIS_PASSWORD"} --no-auth-warning --rdb -`},
+		RestoreFile: "/data/dump.rdb",
+		// With AOF Redis loads the append only file on start and ignores dump.rdb
+		RestoreCheckCmd: []string{"sh", "-c", `AOF=$(redis-cli ${REDIS_PASSWORD:+-a "$REDIS_PASSWORD"} --no-auth-warning config get appendonly | tail -n 1); ` +
+			`if [ "$AOF" = "yes" ]; then echo "AOF is enabled (appendonly yes), Redis would ignore the restored dump.rdb. Disable it for the restore!"; exit 1; fi`},
+	},
+}
+
+// GetDumpStrategy returns the strategy set via label or detected from the image, nil if there is none.
+func GetDumpStrategy(ctr types.Container, settings ContainerSettings) *DumpStrategy {
+	if settings.BackupStrategy != "" {
+		for i, s := range DumpStrategies {
+			if s.Name == settings.BackupStrategy {
+				return &DumpStrategies[i]
+			}
+		}
+		if settings.BackupStrategy != "none" {
+			log.Error("Container ", ctr.Names[0], ": Unknown backup strategy '", settings.BackupStrategy, "', valid values are none, postgres, mysql, mongo, redis")
+		}
+		return nil
+	}
+
+	imageName := ctr.Image
+	if i := strings.Index(imageName, "@"); i != -1 {
+		imageName = imageName[:i]
+	}
+	imageName = imageName[strings.LastIndex(imageName, "/")+1:]
+	if i := strings.Index(imageName, ":"); i != -1 {
+		imageName = imageName[:i]
+	}
+
+	for i, s := range DumpStrategies {
+		if containsString(s.Images, imageName) {
+			return &DumpStrategies[i]
+		}
+	}
+	return nil
+}
+
+func dumpFileName(s *DumpStrategy) string {
+	return "dump_" + s.Name + s.Extension
+}
+
+// backupDump runs the dump of the strategy inside ctr and writes it (compressed and encrypted) into the snapshot.
+func backupDump(ctr types.Container, s *DumpStrategy, snapshotPath string) (ManifestDump, []byte, error) {
+	archivePath := snapshotPath + dumpFileName(s) + archive.CompressionExtension(config.Conf.BackupCompression)
+	if config.Conf.BackupEncryption().Enabled() {
+		archivePath += archive.EncryptionExtension
+	}
+
+	manifestDump := ManifestDump{
+		Strategy: s.Name,
+		Archive:  archivePath[strings.LastIndex(archivePath, "/")+1:],
+		ExitCode: -1,
+	}
+	log.Info("Dumping ", s.Name, " database of container ", ctr.Names[0])
+	start := time.Now()
+
+	res, err := writeArchive(archivePath, func(w io.Writer) ([]byte, int64, error) {
+		return ExecContainer(ctr.ID, s.DumpCmd, nil, w)
+	})
+	if err == nil && res.ExitCode != 0 {
+		_ = os.Remove(archivePath)
+		err = fmt.Errorf("%s dump exited with code %d: %s", s.Name, res.ExitCode, strings.TrimSpace(string(res.Logs)))
+	}
+
+	manifestDump.DurationSeconds = time.Since(start).Seconds()
+	manifestDump.ExitCode = res.ExitCode
+	if err != nil {
+		manifestDump.Error = err.Error()
+		return manifestDump, res.Logs, err
+	}
+	manifestDump.Size = res.Size
+	manifestDump.SHA256 = res.SHA256
+
+	return manifestDump, res.Logs, nil
+}
+
+// RestoreDump restores the database dump of a snapshot into a container.
+// snapshot may be "latest", the container needs to be running unless the strategy restores a file.
+func RestoreDump(containerName, snapshot string) error {
+	containerName = strings.TrimPrefix(containerName, "/")
+	log.Info("RestoreDump ", containerName, " Snapshot: ", snapshot)
+
+	ctr, err := FindContainer(containerName)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockRun("restore-dump of " + containerName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	snapshotPath, err := resolveSnapshot(containerName, snapshot)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := ReadManifest(snapshotPath)
+	if err != nil {
+		return err
+	}
+	if manifest.Dump == nil || manifest.Dump.Error != "" {
+		return errors.New("Snapshot contains no database dump")
+	}
+
+	var s *DumpStrategy
+	for i, ds := range DumpStrategies {
+		if ds.Name == manifest.Dump.Strategy {
+			s = &DumpStrategies[i]
+		}
+	}
+	if s == nil {
+		return errors.New("Unknown backup strategy in manifest: " + manifest.Dump.Strategy)
+	}
+
+	r, closeArchive, err := openArchive(snapshotPath + manifest.Dump.Archive)
+	if err != nil {
+		return err
+	}
+	defer closeArchive()
+
+	if s.RestoreCheckCmd != nil {
+		if ctr.State != "running" {
+			log.Warn("Container ", containerName, " is not running, can't check if the ", s.Name, " dump can be restored")
+		} else {
+			out, exitCode, err := ExecContainer(ctr.ID, s.RestoreCheckCmd, nil, nil)
+			if err != nil {
+				return errors.New("Error checking container before restore: " + err.Error())
+			}
+			if exitCode != 0 {
+				return errors.New("Can't restore " + s.Name + " dump: " + strings.TrimSpace(string(out)))
+			}
+		}
+	}
+
+	var out []byte
+	var exitCode int64
+	if s.RestoreFile != "" {
+		if ctr.State == "running" {
+			log.Info("Stopping container ", containerName)
+			err = cli.ContainerStop(ctx, ctr.ID, container.StopOptions{})
+			if err != nil {
+				return errors.New("Error stopping container: " + err.Error())
+			}
+			defer func() {
+				log.Info("Starting container ", containerName)
+				err := cli.ContainerStart(ctx, ctr.ID, container.StartOptions{})
+				if err != nil {
+					log.Error("Error starting container ", containerName, " after restore: ", err)
+				}
+			}()
+		}
+
+		out, exitCode, err = RunContainerWithStatus(RunContainerParams{
+			ContainerName: "DockerRight-RestoreRunner-" + containerName + "-dump",
+			ImageName:     defImage,
+			Cmd:           []string{"sh", "-c", `cat > "$0"`, s.RestoreFile},
+			Remove:        true,
+			VolumesFrom:   []string{ctr.ID},
+			Stdin:         r,
+		})
+	} else {
+		if ctr.State != "running" {
+			return errors.New("Container " + containerName + " needs to be running to restore a " + s.Name + " dump")
+		}
+		out, exitCode, err = ExecContainer(ctr.ID, s.RestoreCmd, r, nil)
+	}
+	if err != nil {
+		return err
+	}
+	log.Debug("Restore output:", "\n", string(out))
+	if exitCode != 0 {
+		return fmt.Errorf("%s restore exited with code %d: %s", s.Name, exitCode, strings.TrimSpace(string(out)))
+	}
+
+	log.Info("RestoreDump ", containerName, " done")
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bata94/DockerRight/internal/log"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Events of the Docker events stream relevant for monitoring.
+var monitorEvents = []string{"die", "oom", "health_status", "restart", "start", "destroy"}
+
+// exitDebounce is the time a die event is held back. If the container is started again within it
+// (restart policy, docker restart) only the start is reported, the exit is notified as restart via the inspect.
+const exitDebounce = 10 * time.Second
+
+// ContainerEvent is a change of a monitored container, reported by the Docker events stream.
+type ContainerEvent struct {
+	ID     string
+	Name   string
+	Action string
+	// State is the state of the container after the event: running, healthy, unhealthy, exited, oom or removed.
+	State string
+	Time  time.Time
+	// Replayed is true for events from before WatchEvents was called, i.e. while DockerRight was down.
+	Replayed bool
+}
+
+var (
+	// quiesced maps the IDs of the containers paused/stopped for a backup to the time they were resumed,
+	// zero while they still are. Their events until then are not reported, including the start event of the resume.
+	quiesced sync.Map
+	// rebaseline are the IDs of the containers resumed after a backup, their next inspect doesn't count
+	// the pause/stop as exit or restart.
+	rebaseline sync.Map
+)
+
+// isQuiesced is true if the container was paused/stopped for a backup at t.
+func isQuiesced(id string, t time.Time) bool {
+	v, ok := quiesced.Load(id)
+	if !ok {
+		return false
+	}
+	resumed := v.(time.Time)
+	return resumed.IsZero() || !t.After(resumed)
+}
+
+// WatchEvents streams the events of all monitored containers since the given time. If the stream breaks it is reopened,
+// resuming after the last received event, so no event is lost.
+func WatchEvents(since time.Time) <-chan ContainerEvent {
+	out := make(chan ContainerEvent, 64)
+
+	started := time.Now()
+	go func() {
+		d := &exitDebouncer{pending: map[string]*time.Timer{}}
+		for {
+			err := forwardEvents(&since, started, d, out)
+			log.Error("Docker events stream closed, reconnecting in 5 seconds: ", err)
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	return out
+}
+
+// forwardEvents sends the events after since to out until the stream breaks, since is moved past every received event.
+func forwardEvents(since *time.Time, started time.Time, d *exitDebouncer, out chan<- ContainerEvent) error {
+	args := filters.NewArgs(filters.Arg("type", string(events.ContainerEventType)))
+	for _, e := range monitorEvents {
+		args.Add("event", e)
+	}
+
+	msgs, errs := cli.Events(ctx, types.EventsOptions{
+		Since:   fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond()),
+		Filters: args,
+	})
+	log.Info("Watching Docker events")
+
+	for {
+		select {
+		case msg := <-msgs:
+			// Resubscribing starts right after this event
+			*since = time.Unix(0, msg.TimeNano+1)
+			ev, ok := containerEvent(msg)
+			ev.Replayed = ev.Time.Before(started)
+			if ok {
+				log.Debug("Container event: ", ev.Name, " ", ev.Action)
+				d.forward(ev, out)
+			}
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// exitDebouncer holds back exit events for exitDebounce, see forward.
+type exitDebouncer struct {
+	mu      sync.Mutex
+	pending map[string]*time.Timer
+}
+
+// forward sends ev to out, exit events only if the container isn't started again within exitDebounce.
+func (d *exitDebouncer) forward(ev ContainerEvent, out chan<- ContainerEvent) {
+	d.mu.Lock()
+	if ev.State == "exited" {
+		if t, ok := d.pending[ev.ID]; ok {
+			t.Stop()
+		}
+		var timer *time.Timer
+		timer = time.AfterFunc(exitDebounce, func() {
+			d.mu.Lock()
+			if d.pending[ev.ID] == timer {
+				delete(d.pending, ev.ID)
+			}
+			d.mu.Unlock()
+			out <- ev
+		})
+		d.pending[ev.ID] = timer
+		d.mu.Unlock()
+		return
+	}
+	if t, ok := d.pending[ev.ID]; ok && ev.State == "running" {
+		if t.Stop() {
+			log.Debug("Container ", ev.Name, " was started again within ", exitDebounce, ", not reporting its exit")
+		}
+		delete(d.pending, ev.ID)
+	}
+	d.mu.Unlock()
+
+	out <- ev
+}
+
+// containerEvent maps a Docker event to a ContainerEvent, ok is false if the event is not relevant for the monitor.
+func containerEvent(msg events.Message) (ContainerEvent, bool) {
+	name := msg.Actor.Attributes["name"]
+	ev := ContainerEvent{
+		ID:     msg.Actor.ID,
+		Name:   name,
+		Action: string(msg.Action),
+		Time:   time.Unix(0, msg.TimeNano),
+	}
+
+	if (ownContainerID != "" && ev.ID == ownContainerID) || strings.Contains(strings.ToLower(name), "dockerright") {
+		return ev, false
+	}
+	if isQuiesced(ev.ID, ev.Time) && ev.Action != "destroy" {
+		log.Debug("Ignoring event ", ev.Action, " of ", name, ", it is quiesced for a backup")
+		return ev, false
+	}
+	// The container labels are part of the event attributes
+	if !GetContainerSettings("/"+name, msg.Actor.Attributes).MonitorEnable {
+		return ev, false
+	}
+
+	switch {
+	case ev.Action == "start" || ev.Action == "restart":
+		ev.State = "running"
+	case ev.Action == "die":
+		ev.State = "exited"
+	case ev.Action == "oom":
+		ev.State = "oom"
+	case ev.Action == "destroy":
+		ev.State = "removed"
+	case strings.HasPrefix(ev.Action, "health_status"):
+		// i.e. "health_status: unhealthy"
+		_, status, _ := strings.Cut(ev.Action, ":")
+		status = strings.TrimSpace(status)
+		if status != "healthy" && status != "unhealthy" {
+			return ev, false
+		}
+		ev.State = status
+	default:
+		return ev, false
+	}
+
+	return ev, true
+}
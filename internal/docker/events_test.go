@@ -0,0 +1,70 @@
+package docker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+)
+
+func TestContainerEvent(t *testing.T) {
+	now := time.Now()
+	ownContainerID = "own"
+	quiesced.Store("paused", time.Time{})
+	quiesced.Store("resumed", now.Add(-time.Minute))
+	t.Cleanup(func() {
+		ownContainerID = ""
+		quiesced.Delete("paused")
+		quiesced.Delete("resumed")
+	})
+
+	msg := func(id, name, action string, attributes map[string]string) events.Message {
+		attrs := map[string]string{"name": name}
+		for k, v := range attributes {
+			attrs[k] = v
+		}
+		return events.Message{
+			Action:   events.Action(action),
+			Actor:    events.Actor{ID: id, Attributes: attrs},
+			TimeNano: now.UnixNano(),
+		}
+	}
+
+	tests := []struct {
+		name      string
+		msg       events.Message
+		wantState string
+		wantOk    bool
+	}{
+		{"start", msg("app", "app", "start", nil), "running", true},
+		{"restart", msg("app", "app", "restart", nil), "running", true},
+		{"die", msg("app", "app", "die", nil), "exited", true},
+		{"oom", msg("app", "app", "oom", nil), "oom", true},
+		{"destroy", msg("app", "app", "destroy", nil), "removed", true},
+		{"unhealthy", msg("app", "app", "health_status: unhealthy", nil), "unhealthy", true},
+		{"healthy", msg("app", "app", "health_status: healthy", nil), "healthy", true},
+		{"health starting", msg("app", "app", "health_status: starting", nil), "", false},
+		{"other action", msg("app", "app", "exec_start", nil), "", false},
+		{"own container", msg("own", "app", "die", nil), "", false},
+		{"dockerright by name", msg("app", "DockerRight-1", "die", nil), "", false},
+		{"monitor disabled", msg("app", "app", "die", map[string]string{LabelMonitorEnable: "false"}), "", false},
+		{"quiesced", msg("paused", "app", "die", nil), "", false},
+		{"quiesced destroy", msg("paused", "app", "destroy", nil), "removed", true},
+		{"after resume", msg("resumed", "app", "die", nil), "exited", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, ok := containerEvent(tt.msg)
+			if ok != tt.wantOk {
+				t.Fatalf("containerEvent() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if ok && ev.State != tt.wantState {
+				t.Errorf("containerEvent() State = %q, want %q", ev.State, tt.wantState)
+			}
+			if ev.ID != tt.msg.Actor.ID || ev.Name != tt.msg.Actor.Attributes["name"] {
+				t.Errorf("containerEvent() = %+v, want ID %q and Name %q", ev, tt.msg.Actor.ID, tt.msg.Actor.Attributes["name"])
+			}
+		})
+	}
+}
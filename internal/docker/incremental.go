@@ -0,0 +1,168 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bata94/DockerRight/internal/config"
+	"github.com/bata94/DockerRight/internal/log"
+	"github.com/bata94/DockerRight/internal/storage"
+
+	"github.com/docker/docker/api/types"
+)
+
+// snarPath is the GNU tar snapshot file inside the backup helper container.
+const snarPath = "/tmp/dockerright.snar"
+
+// incrementalState is the state of the incremental chain of a single mount, saved next to the
+// GNU tar snapshot file (.snar) in the config directory, so no file names end up unencrypted in the BackupPath.
+type incrementalState struct {
+	// Snapshot is the last successful snapshot of the chain, the next incremental is based on it.
+	Snapshot string
+	Level    int
+}
+
+func incrementalStatePath(containerName string, m types.MountPoint) string {
+	return filepath.Dir(config.ConfigPath) + "/incremental/" + containerName + "/" + mountArchiveName(m)
+}
+
+// planIncremental returns the level and base snapshot of the next backup of a mount and the .snar file to start from.
+// A full backup (level 0) is made if there is no usable state or the chain reached BackupIncrementalFullEvery.
+func planIncremental(containerName string, m types.MountPoint) (int, string, []byte) {
+	statePath := incrementalStatePath(containerName, m)
+
+	stateFile, err := os.ReadFile(statePath + ".json")
+	if err != nil {
+		return 0, "", nil
+	}
+	state := incrementalState{}
+	err = json.Unmarshal(stateFile, &state)
+	if err != nil {
+		log.Error("Error reading incremental state of ", containerName, " ", m.Destination, ", creating a full backup: ", err)
+		return 0, "", nil
+	}
+
+	if state.Level+1 >= config.Conf.BackupIncrementalFullEvery {
+		log.Info("Incremental chain of ", containerName, " ", m.Destination, " is complete, creating a full backup")
+		return 0, "", nil
+	}
+
+	// The base has to exist in every target, i.e. it might have been deleted by hand
+	for _, t := range targets {
+		if !snapshotExists(t.Store, containerName, state.Snapshot) {
+			log.Warn("Base snapshot ", state.Snapshot, " of ", containerName, " ", m.Destination, " is missing in ", t.Name, ", creating a full backup")
+			return 0, "", nil
+		}
+	}
+
+	snar, err := os.ReadFile(statePath + ".snar")
+	if err != nil {
+		log.Error("Error reading incremental state of ", containerName, " ", m.Destination, ", creating a full backup: ", err)
+		return 0, "", nil
+	}
+
+	return state.Level + 1, state.Snapshot, snar
+}
+
+func saveIncrementalState(containerName string, m types.MountPoint, snapshot string, level int, snar []byte) error {
+	statePath := incrementalStatePath(containerName, m)
+	err := os.MkdirAll(filepath.Dir(statePath), 0o700)
+	if err != nil {
+		return err
+	}
+
+	stateFile, err := json.Marshal(incrementalState{Snapshot: snapshot, Level: level})
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(statePath+".snar", snar, 0o600)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(statePath+".json", stateFile, 0o600)
+}
+
+func resetIncrementalState(containerName string, m types.MountPoint) {
+	statePath := incrementalStatePath(containerName, m)
+	_ = os.Remove(statePath + ".json")
+	_ = os.Remove(statePath + ".snar")
+}
+
+// archiveChain returns the archives needed to restore a mount, oldest (full backup) first.
+// incremental is true if the archives have to be extracted as GNU tar incrementals.
+func archiveChain(snapshotPath string, m types.MountPoint) ([]string, bool, error) {
+	archivePath, err := findMountArchive(snapshotPath, m)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Shared archives are followed into the snapshot of the container, which archived it
+	snapshotPath = archivePath[:strings.LastIndex(archivePath, "/")+1]
+	archiveName := archivePath[strings.LastIndex(archivePath, "/")+1:]
+	manifest, err := ReadManifest(snapshotPath)
+	if err != nil {
+		// Snapshots without manifest are always full backups
+		return []string{archivePath}, false, nil
+	}
+	mm, ok := findManifestMount(manifest, func(mm ManifestMount) bool { return mm.Archive == archiveName })
+	if !ok {
+		return nil, false, errors.New("Archive " + archiveName + " not found in manifest")
+	}
+
+	chain := []string{archivePath}
+	for mm.Incremental && mm.Level > 0 {
+		snapshotPath = snapshotPath[:strings.LastIndex(strings.TrimSuffix(snapshotPath, "/"), "/")+1] + mm.BaseSnapshot + "/"
+		destination := mm.Destination
+		manifest, err = ReadManifest(snapshotPath)
+		if err != nil {
+			return nil, false, errors.New("Error reading base snapshot " + mm.BaseSnapshot + ": " + err.Error())
+		}
+		mm, ok = findManifestMount(manifest, func(mm ManifestMount) bool { return mm.Destination == destination && mm.SharedArchive == "" })
+		if !ok || mm.BackupError() != "" {
+			return nil, false, errors.New("Base archive of " + destination + " missing in snapshot " + manifest.Snapshot)
+		}
+		chain = append([]string{snapshotPath + mm.Archive}, chain...)
+	}
+
+	return chain, mm.Incremental, nil
+}
+
+// requiredBaseSnapshots returns all snapshots of a container, which incrementals of the kept snapshots depend on.
+func requiredBaseSnapshots(b storage.Backend, containerName string, kept []string) map[string]bool {
+	required := map[string]bool{}
+	// followed are the chains already followed per base snapshot and mount, a base of one mount might be
+	// reached first via another mount, its own chain still has to be followed
+	followed := map[string]bool{}
+	for _, snapshot := range kept {
+		manifest, err := readManifest(b, snapshotKey(containerName, snapshot))
+		if err != nil {
+			continue
+		}
+		for _, mm := range manifest.Mounts {
+			for mm.Incremental && mm.Level > 0 && !followed[mm.BaseSnapshot+":"+mm.Destination] {
+				required[mm.BaseSnapshot] = true
+				followed[mm.BaseSnapshot+":"+mm.Destination] = true
+				destination := mm.Destination
+				base, err := readManifest(b, snapshotKey(containerName, mm.BaseSnapshot))
+				if err != nil {
+					log.Error("Error reading base snapshot ", mm.BaseSnapshot, " of ", containerName, ": ", err)
+					break
+				}
+				mm, _ = findManifestMount(base, func(mm ManifestMount) bool { return mm.Destination == destination && mm.SharedArchive == "" })
+			}
+		}
+	}
+	return required
+}
+
+func findManifestMount(manifest Manifest, match func(ManifestMount) bool) (ManifestMount, bool) {
+	for _, mm := range manifest.Mounts {
+		if match(mm) {
+			return mm, true
+		}
+	}
+	return ManifestMount{}, false
+}
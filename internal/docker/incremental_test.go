@@ -0,0 +1,246 @@
+package docker
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bata94/DockerRight/internal/storage"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+)
+
+var testMount = types.MountPoint{Type: mount.TypeVolume, Name: "data", Destination: "/data"}
+
+// testArchive is the archive name of testMount inside a snapshot.
+var testArchive = mountArchiveName(testMount) + ".tar.gz"
+
+// full, incremental and shared build the manifest mounts of testMount.
+func full() ManifestMount {
+	return ManifestMount{Type: "volume", Destination: "/data", Archive: testArchive}
+}
+
+func incremental(level int, base string) ManifestMount {
+	return ManifestMount{Type: "volume", Destination: "/data", Archive: testArchive, Incremental: true, Level: level, BaseSnapshot: base}
+}
+
+func shared(owner, snapshot string) ManifestMount {
+	return ManifestMount{Type: "volume", Destination: "/data", SharedArchive: owner + "/" + snapshot + "/" + testArchive, TarExitCode: -1}
+}
+
+// putSnapshot stores a snapshot with its manifest and an archive for every mount with one.
+func putSnapshot(t *testing.T, b storage.Backend, containerName, snapshot string, mounts ...ManifestMount) {
+	t.Helper()
+	manifestFile, err := json.Marshal(Manifest{Container: containerName, Snapshot: snapshot, Mounts: mounts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Put(snapshotKey(containerName, snapshot)+manifestFileName, strings.NewReader(string(manifestFile)), int64(len(manifestFile)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mm := range mounts {
+		if mm.Archive == "" {
+			continue
+		}
+		err = b.Put(snapshotKey(containerName, snapshot)+mm.Archive, strings.NewReader("archive"), 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// useStore replaces the store restore reads from for the test.
+func useStore(t *testing.T, b storage.Backend) {
+	old := store
+	store = b
+	t.Cleanup(func() { store = old })
+}
+
+func TestArchiveChain(t *testing.T) {
+	tests := []struct {
+		name string
+		// snapshots are stored by container and snapshot
+		snapshots       map[string]map[string][]ManifestMount
+		container       string
+		snapshot        string
+		want            []string
+		wantIncremental bool
+		wantErr         bool
+	}{
+		{
+			name:      "full backup",
+			snapshots: map[string]map[string][]ManifestMount{"app": {"s1": {full()}}},
+			container: "app", snapshot: "s1",
+			want: []string{"app/s1/" + testArchive},
+		},
+		{
+			name: "incremental chain",
+			snapshots: map[string]map[string][]ManifestMount{"app": {
+				"s1": {incremental(0, "")},
+				"s2": {incremental(1, "s1")},
+				"s3": {incremental(2, "s2")},
+			}},
+			container: "app", snapshot: "s3",
+			want:            []string{"app/s1/" + testArchive, "app/s2/" + testArchive, "app/s3/" + testArchive},
+			wantIncremental: true,
+		},
+		{
+			name: "full backup of a chain",
+			snapshots: map[string]map[string][]ManifestMount{"app": {
+				"s1": {incremental(0, "")},
+				"s2": {incremental(1, "s1")},
+			}},
+			container: "app", snapshot: "s1",
+			want:            []string{"app/s1/" + testArchive},
+			wantIncremental: true,
+		},
+		{
+			name: "broken link",
+			snapshots: map[string]map[string][]ManifestMount{"app": {
+				"s1": {incremental(0, "")},
+				"s3": {incremental(2, "s2")},
+			}},
+			container: "app", snapshot: "s3",
+			wantErr: true,
+		},
+		{
+			name: "failed base archive",
+			snapshots: map[string]map[string][]ManifestMount{"app": {
+				"s1": {func() ManifestMount { mm := incremental(0, ""); mm.Error = "tar exited with code 2"; return mm }()},
+				"s2": {incremental(1, "s1")},
+			}},
+			container: "app", snapshot: "s2",
+			wantErr: true,
+		},
+		{
+			name: "shared archive",
+			snapshots: map[string]map[string][]ManifestMount{
+				"app":    {"s1": {full()}},
+				"worker": {"s1": {shared("app", "s1")}},
+			},
+			container: "worker", snapshot: "s1",
+			want: []string{"app/s1/" + testArchive},
+		},
+		{
+			name: "shared incremental archive",
+			snapshots: map[string]map[string][]ManifestMount{
+				"app":    {"s1": {incremental(0, "")}, "s2": {incremental(1, "s1")}},
+				"worker": {"s2": {shared("app", "s2")}},
+			},
+			container: "worker", snapshot: "s2",
+			want:            []string{"app/s1/" + testArchive, "app/s2/" + testArchive},
+			wantIncremental: true,
+		},
+		{
+			name:      "missing archive",
+			snapshots: map[string]map[string][]ManifestMount{"app": {"s1": {}}},
+			container: "app", snapshot: "s1",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := storage.NewLocal(t.TempDir())
+			useStore(t, b)
+			for containerName, snapshots := range tt.snapshots {
+				for snapshot, mounts := range snapshots {
+					putSnapshot(t, b, containerName, snapshot, mounts...)
+				}
+			}
+
+			got, gotIncremental, err := archiveChain(snapshotKey(tt.container, tt.snapshot), testMount)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("archiveChain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) || gotIncremental != tt.wantIncremental {
+				t.Errorf("archiveChain() = %v, %v, want %v, %v", got, gotIncremental, tt.want, tt.wantIncremental)
+			}
+		})
+	}
+}
+
+func TestRequiredBaseSnapshots(t *testing.T) {
+	config := types.MountPoint{Type: mount.TypeBind, Source: "/srv/config", Destination: "/config"}
+	configArchive := mountArchiveName(config) + ".tar.gz"
+
+	tests := []struct {
+		name      string
+		snapshots map[string][]ManifestMount
+		kept      []string
+		want      map[string]bool
+	}{
+		{
+			name:      "full backups",
+			snapshots: map[string][]ManifestMount{"s1": {full()}, "s2": {full()}},
+			kept:      []string{"s2"},
+			want:      map[string]bool{},
+		},
+		{
+			name: "whole chain of the newest",
+			snapshots: map[string][]ManifestMount{
+				"s1": {incremental(0, "")},
+				"s2": {incremental(1, "s1")},
+				"s3": {incremental(2, "s2")},
+			},
+			kept: []string{"s3"},
+			want: map[string]bool{"s1": true, "s2": true},
+		},
+		{
+			name: "new chain after a full backup",
+			snapshots: map[string][]ManifestMount{
+				"s1": {incremental(0, "")},
+				"s2": {incremental(1, "s1")},
+				"s3": {incremental(0, "")},
+				"s4": {incremental(1, "s3")},
+			},
+			kept: []string{"s4"},
+			want: map[string]bool{"s3": true},
+		},
+		{
+			name: "broken link",
+			snapshots: map[string][]ManifestMount{
+				"s1": {incremental(0, "")},
+				"s3": {incremental(2, "s2")},
+			},
+			kept: []string{"s3"},
+			want: map[string]bool{"s2": true},
+		},
+		{
+			name: "chains of multiple mounts",
+			snapshots: map[string][]ManifestMount{
+				"s1": {incremental(0, ""), {Type: "bind", Destination: "/config", Archive: configArchive, Incremental: true}},
+				"s2": {incremental(0, ""), {Type: "bind", Destination: "/config", Archive: configArchive, Incremental: true, Level: 1, BaseSnapshot: "s1"}},
+				"s3": {incremental(1, "s2"), {Type: "bind", Destination: "/config", Archive: configArchive, Incremental: true, Level: 2, BaseSnapshot: "s2"}},
+			},
+			kept: []string{"s3"},
+			want: map[string]bool{"s1": true, "s2": true},
+		},
+		{
+			name:      "kept snapshot without manifest",
+			snapshots: map[string][]ManifestMount{"s1": {incremental(0, "")}},
+			kept:      []string{"s2"},
+			want:      map[string]bool{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := storage.NewLocal(t.TempDir())
+			for snapshot, mounts := range tt.snapshots {
+				putSnapshot(t, b, "app", snapshot, mounts...)
+			}
+
+			got := requiredBaseSnapshots(b, "app", tt.kept)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requiredBaseSnapshots(%v) = %v, want %v", tt.kept, got, tt.want)
+			}
+		})
+	}
+}
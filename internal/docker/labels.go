@@ -0,0 +1,205 @@
+package docker
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/bata94/DockerRight/internal/config"
+	"github.com/bata94/DockerRight/internal/log"
+	"github.com/bata94/DockerRight/internal/retention"
+)
+
+// Container labels to override the global config per container.
+const (
+	LabelBackupEnable        = "dockerright.backup.enable"
+	LabelBackupExcludeMounts = "dockerright.backup.exclude-mounts"
+	LabelBackupHours         = "dockerright.backup.hours"
+	LabelBackupQuiesce       = "dockerright.backup.quiesce"
+	LabelBackupSchedule      = "dockerright.backup.schedule"
+	LabelBackupStrategy      = "dockerright.backup.strategy"
+	LabelMonitorEnable       = "dockerright.monitor.enable"
+	LabelMonitorCPU          = "dockerright.monitor.cpu-percent"
+	LabelMonitorMemory       = "dockerright.monitor.memory-percent"
+	LabelMonitorBlockIO      = "dockerright.monitor.blockio-mbps"
+	LabelMonitorNetwork      = "dockerright.monitor.network-mbps"
+	LabelMonitorProbe        = "dockerright.monitor.probe"
+	LabelMonitorProbeStatus  = "dockerright.monitor.probe-status"
+	LabelMonitorProbeBody    = "dockerright.monitor.probe-body"
+	LabelRetentionHours      = "dockerright.retention.hours"
+	LabelRetentionPolicy     = "dockerright.retention.policy"
+)
+
+// ContainerSettings are the settings of a single container, the global config overridden by the container labels.
+type ContainerSettings struct {
+	BackupEnable        bool
+	BackupExcludeMounts []string
+	BackupSchedule      []string
+	BackupQuiesce       string
+	BackupStrategy      string
+	MonitorEnable       bool
+	// Resource usage thresholds, 0 disables a threshold
+	MonitorCPUPercent    int
+	MonitorMemoryPercent int
+	MonitorBlockIOMBps   int
+	MonitorNetworkMBps   int
+	// MonitorProbe checks containers without a HEALTHCHECK, nil if there is none
+	MonitorProbe    *config.Probe
+	RetentionPolicy retention.Policy
+}
+
+func GetContainerSettings(containerName string, labels map[string]string) ContainerSettings {
+	s := ContainerSettings{
+		BackupEnable:         true,
+		BackupExcludeMounts:  []string{},
+		BackupSchedule:       config.Conf.Schedules(),
+		BackupQuiesce:        config.Conf.BackupQuiesce,
+		MonitorEnable:        true,
+		MonitorCPUPercent:    config.Conf.MonitorCPUPercent,
+		MonitorMemoryPercent: config.Conf.MonitorMemoryPercent,
+		MonitorBlockIOMBps:   config.Conf.MonitorBlockIOMBps,
+		MonitorNetworkMBps:   config.Conf.MonitorNetworkMBps,
+		RetentionPolicy:      config.Conf.Retention(),
+	}
+
+	if schedule, ok := config.Conf.BackupContainerSchedules[strings.TrimPrefix(containerName, "/")]; ok {
+		s.BackupSchedule = schedule
+	}
+	if val, ok := labels[LabelBackupEnable]; ok {
+		s.BackupEnable = parseBoolLabel(containerName, LabelBackupEnable, val, s.BackupEnable)
+	}
+	if val, ok := labels[LabelMonitorEnable]; ok {
+		s.MonitorEnable = parseBoolLabel(containerName, LabelMonitorEnable, val, s.MonitorEnable)
+	}
+	if val, ok := labels[LabelMonitorCPU]; ok {
+		s.MonitorCPUPercent = parseIntLabel(containerName, LabelMonitorCPU, val, s.MonitorCPUPercent)
+	}
+	if val, ok := labels[LabelMonitorMemory]; ok {
+		s.MonitorMemoryPercent = parseIntLabel(containerName, LabelMonitorMemory, val, s.MonitorMemoryPercent)
+	}
+	if val, ok := labels[LabelMonitorBlockIO]; ok {
+		s.MonitorBlockIOMBps = parseIntLabel(containerName, LabelMonitorBlockIO, val, s.MonitorBlockIOMBps)
+	}
+	if val, ok := labels[LabelMonitorNetwork]; ok {
+		s.MonitorNetworkMBps = parseIntLabel(containerName, LabelMonitorNetwork, val, s.MonitorNetworkMBps)
+	}
+	if probe, ok := config.Conf.MonitorProbes[strings.TrimPrefix(containerName, "/")]; ok {
+		s.MonitorProbe = &probe
+	}
+	if val, ok := labels[LabelMonitorProbe]; ok {
+		probe := config.Probe{URL: strings.TrimSpace(val), Body: labels[LabelMonitorProbeBody]}
+		if status, ok := labels[LabelMonitorProbeStatus]; ok {
+			probe.Status = parseIntLabel(containerName, LabelMonitorProbeStatus, status, 0)
+		}
+		err := probe.Validate()
+		if err != nil {
+			log.Error("Container ", containerName, ": Label '", LabelMonitorProbe, "' could not be parsed... ", err)
+			log.Warn("Falling back to global MonitorProbes!")
+		} else {
+			s.MonitorProbe = &probe
+		}
+	}
+	if val, ok := labels[LabelBackupExcludeMounts]; ok {
+		for _, m := range strings.Split(val, ",") {
+			m = strings.TrimSpace(m)
+			if m != "" {
+				s.BackupExcludeMounts = append(s.BackupExcludeMounts, strings.TrimSuffix(m, "/"))
+			}
+		}
+	}
+	if val, ok := labels[LabelBackupHours]; ok {
+		backupHours := []int{}
+		for _, h := range strings.Split(val, ",") {
+			hourInt, err := strconv.Atoi(strings.TrimSpace(h))
+			if err != nil || hourInt < 0 || hourInt > 23 {
+				log.Error("Container ", containerName, ": Label '", LabelBackupHours, "' could not be parsed... value read: ", val)
+				log.Warn("Falling back to global BackupSchedule!")
+				backupHours = nil
+				break
+			}
+			backupHours = append(backupHours, hourInt)
+		}
+		if backupHours != nil {
+			s.BackupSchedule = config.HoursToSchedules(backupHours)
+		}
+	}
+	if val, ok := labels[LabelBackupSchedule]; ok {
+		backupSchedule := []string{}
+		for _, spec := range strings.Split(val, ";") {
+			spec = strings.TrimSpace(spec)
+			if spec == "" {
+				continue
+			}
+			_, err := cron.ParseStandard(spec)
+			if err != nil {
+				log.Error("Container ", containerName, ": Label '", LabelBackupSchedule, "' could not be parsed... ", err)
+				log.Warn("Falling back to global BackupSchedule!")
+				backupSchedule = nil
+				break
+			}
+			backupSchedule = append(backupSchedule, spec)
+		}
+		if backupSchedule != nil {
+			s.BackupSchedule = backupSchedule
+		}
+	}
+	if val, ok := labels[LabelBackupQuiesce]; ok {
+		if ValidQuiesceMode(val) {
+			s.BackupQuiesce = strings.ToLower(strings.TrimSpace(val))
+		} else {
+			log.Error("Container ", containerName, ": Label '", LabelBackupQuiesce, "' could not be parsed... value read: ", val)
+			log.Warn("Falling back to global BackupQuiesce!")
+		}
+	}
+	if val, ok := labels[LabelBackupStrategy]; ok {
+		s.BackupStrategy = strings.ToLower(strings.TrimSpace(val))
+	}
+	if val, ok := labels[LabelRetentionHours]; ok {
+		valInt, err := strconv.Atoi(strings.TrimSpace(val))
+		if err != nil {
+			log.Error("Container ", containerName, ": Label '", LabelRetentionHours, "' could not be parsed... value read: ", val)
+			log.Warn("Falling back to global RetentionHours!")
+		} else {
+			s.RetentionPolicy = retention.Policy{Within: valInt}
+		}
+	}
+	if val, ok := labels[LabelRetentionPolicy]; ok {
+		p, err := retention.ParsePolicy(val)
+		if err != nil {
+			log.Error("Container ", containerName, ": Label '", LabelRetentionPolicy, "' could not be parsed... ", err)
+			log.Warn("Falling back to global RetentionPolicy!")
+		} else {
+			s.RetentionPolicy = p
+		}
+	}
+
+	return s
+}
+
+func (s ContainerSettings) MountExcluded(destination string) bool {
+	return containsString(s.BackupExcludeMounts, strings.TrimSuffix(destination, "/"))
+}
+
+func parseBoolLabel(containerName, label, val string, def bool) bool {
+	switch strings.ToLower(strings.TrimSpace(val)) {
+	case "true":
+		return true
+	case "false":
+		return false
+	default:
+		log.Error("Container ", containerName, ": Label '", label, "' could not be parsed... value read: ", val)
+		log.Warn("Falling back to default value!")
+		return def
+	}
+}
+
+func parseIntLabel(containerName, label, val string, def int) int {
+	valInt, err := strconv.Atoi(strings.TrimSpace(val))
+	if err != nil || valInt < 0 {
+		log.Error("Container ", containerName, ": Label '", label, "' could not be parsed... value read: ", val)
+		log.Warn("Falling back to default value!")
+		return def
+	}
+	return valInt
+}
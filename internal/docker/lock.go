@@ -0,0 +1,89 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bata94/DockerRight/internal/config"
+)
+
+const lockFileName = ".dockerright.lock"
+
+var (
+	// runMu serializes backup runs inside this process, runsQueued are the groups running or waiting for it.
+	runMu        sync.Mutex
+	runsQueuedMu sync.Mutex
+	runsQueued   = map[string]bool{}
+)
+
+// lockRun waits until no other backup run is active and returns the func to release the lock.
+// It fails if a run of the same group is already running or waiting, or another DockerRight instance
+// holds the lock file in the BackupPath.
+func lockRun(group string) (func(), error) {
+	runsQueuedMu.Lock()
+	if runsQueued[group] {
+		runsQueuedMu.Unlock()
+		return nil, errors.New("Backup run of " + group + " is already running or waiting, skipping")
+	}
+	runsQueued[group] = true
+	runsQueuedMu.Unlock()
+
+	dequeue := func() {
+		runsQueuedMu.Lock()
+		delete(runsQueued, group)
+		runsQueuedMu.Unlock()
+	}
+
+	runMu.Lock()
+	unlockFile, err := lockFile()
+	if err != nil {
+		runMu.Unlock()
+		dequeue()
+		return nil, err
+	}
+
+	return func() {
+		unlockFile()
+		runMu.Unlock()
+		dequeue()
+	}, nil
+}
+
+// lockFile takes an exclusive flock on the lock file in the BackupPath, it is released by the kernel if the process dies.
+// flock is not reliable on NFS/CIFS, instances sharing a BackupPath on a network share might not see each other's lock.
+func lockFile() (func(), error) {
+	err := os.MkdirAll(config.Conf.BackupPath, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	path := strings.TrimSuffix(config.Conf.BackupPath, "/") + "/" + lockFileName
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, errors.New("Error opening lock file: " + err.Error())
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		holder, _ := io.ReadAll(f)
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errors.New("Another DockerRight instance is running a backup (" + strings.TrimSpace(string(holder)) + "), skipping")
+		}
+		return nil, errors.New("Error locking " + path + ": " + err.Error())
+	}
+
+	hostname, _ := os.Hostname()
+	_ = f.Truncate(0)
+	_, _ = f.WriteAt([]byte(fmt.Sprint("host ", hostname, " pid ", os.Getpid(), " since ", time.Now().Format(time.RFC3339), "\n")), 0)
+
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,233 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bata94/DockerRight/internal/log"
+	"github.com/bata94/DockerRight/internal/storage"
+)
+
+const manifestFileName = "manifest.json"
+
+// Manifest describes a snapshot directory, it is written as manifest.json next to the archives.
+type Manifest struct {
+	Container   string
+	ContainerID string
+	Image       string
+	Snapshot    string
+	Created     time.Time
+	Compression string
+	Encrypted   bool
+	Mounts      []ManifestMount
+	Dump        *ManifestDump `json:",omitempty"`
+}
+
+type ManifestMount struct {
+	Type        string
+	Source      string
+	Destination string
+	// Archive is the file name of the archive inside the snapshot directory.
+	Archive string
+	// SharedArchive is set instead of Archive, if the mount is shared with another container, which archived it.
+	// It is the path of the archive relative to the BackupPath (its key in the store).
+	SharedArchive string `json:",omitempty"`
+	// SharedWith lists the other containers, which reference this archive.
+	SharedWith []string `json:",omitempty"`
+	// Incremental archives (GNU tar --listed-incremental) of Level > 0 depend on the same mount in BaseSnapshot.
+	Incremental     bool   `json:",omitempty"`
+	Level           int    `json:",omitempty"`
+	BaseSnapshot    string `json:",omitempty"`
+	Size            int64
+	SHA256          string
+	TarExitCode     int64
+	DurationSeconds float64
+	Error           string
+}
+
+// WriteManifest writes the manifest into a local snapshot directory, before it is stored.
+// BackupError is the error of the backup of the mount, empty if the archive is usable.
+// Manifests written before a tar exit code above 1 failed the mount only record the exit code.
+func (m ManifestMount) BackupError() string {
+	if m.Error == "" && m.TarExitCode > 1 {
+		return fmt.Sprint("tar exited with code ", m.TarExitCode)
+	}
+	return m.Error
+}
+
+func WriteManifest(snapshotPath string, manifest Manifest) error {
+	manifestFile, err := json.MarshalIndent(manifest, "", " ")
+	if err != nil {
+		return errors.New("Error marshalling manifest: " + err.Error())
+	}
+	err = os.WriteFile(strings.TrimSuffix(snapshotPath, "/")+"/"+manifestFileName, manifestFile, 0o644)
+	if err != nil {
+		return errors.New("Error writing manifest: " + err.Error())
+	}
+	return nil
+}
+
+// ReadManifest reads the manifest of a stored snapshot, snapshotKey is the key prefix of the snapshot (see snapshotKey).
+func ReadManifest(snapshotKey string) (Manifest, error) {
+	return readManifest(store, snapshotKey)
+}
+
+func readManifest(b storage.Backend, snapshotKey string) (Manifest, error) {
+	manifest := Manifest{}
+	r, err := b.Get(strings.TrimSuffix(snapshotKey, "/") + "/" + manifestFileName)
+	if err != nil {
+		return manifest, errors.New("Error reading manifest: " + err.Error())
+	}
+	defer r.Close()
+	manifestFile, err := io.ReadAll(r)
+	if err != nil {
+		return manifest, errors.New("Error reading manifest: " + err.Error())
+	}
+	err = json.Unmarshal(manifestFile, &manifest)
+	if err != nil {
+		return manifest, errors.New("Error unmarshalling manifest: " + err.Error())
+	}
+	return manifest, nil
+}
+
+// ManifestDump describes the database dump of a snapshot, created by a DumpStrategy.
+type ManifestDump struct {
+	Strategy        string
+	Archive         string
+	Size            int64
+	SHA256          string
+	ExitCode        int64
+	DurationSeconds float64
+	Error           string
+}
+
+// hashWriter counts and hashes everything written through it.
+type hashWriter struct {
+	w    io.Writer
+	size int64
+	sum  hash.Hash
+}
+
+func newHashWriter(w io.Writer) *hashWriter {
+	return &hashWriter{w: w, sum: sha256.New()}
+}
+
+func (h *hashWriter) Write(p []byte) (int, error) {
+	n, err := h.w.Write(p)
+	h.size += int64(n)
+	_, _ = h.sum.Write(p[:n])
+	return n, err
+}
+
+func (h *hashWriter) SHA256() string {
+	return hex.EncodeToString(h.sum.Sum(nil))
+}
+
+func hashObject(key string) (string, int64, error) {
+	r, err := store.Get(key)
+	if err != nil {
+		return "", 0, err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// VerifySnapshot re-hashes every archive of a snapshot and compares it against its manifest.
+func VerifySnapshot(containerName, snapshot string) error {
+	containerName = strings.TrimPrefix(containerName, "/")
+	log.Info("VerifySnapshot ", containerName, " Snapshot: ", snapshot)
+
+	snapshotPath := snapshotKey(containerName, snapshot)
+	manifest, err := ReadManifest(snapshotPath)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, m := range manifest.Mounts {
+		var err error
+		if m.SharedArchive != "" && m.BackupError() != "" {
+			err = errors.New("Shared archive " + m.SharedArchive + " failed: " + m.BackupError())
+		} else if m.SharedArchive != "" {
+			err = verifySharedArchive(m.SharedArchive)
+		} else {
+			err = verifyArchive(snapshotPath, m.Archive, m.Size, m.SHA256, m.BackupError())
+		}
+		if err != nil {
+			log.Error(containerName, "/", snapshot, ": ", err)
+			failed++
+			continue
+		}
+		if m.SharedArchive == "" && m.TarExitCode != 0 {
+			log.Warn(containerName, "/", snapshot, ": Archive ", m.Archive, " is intact, but tar exited with code ", m.TarExitCode)
+		}
+		log.Info(containerName, "/", snapshot, ": Archive ", m.Archive+m.SharedArchive, " OK")
+	}
+
+	total := len(manifest.Mounts)
+	if manifest.Dump != nil {
+		total++
+		err := verifyArchive(snapshotPath, manifest.Dump.Archive, manifest.Dump.Size, manifest.Dump.SHA256, manifest.Dump.Error)
+		if err != nil {
+			log.Error(containerName, "/", snapshot, ": ", err)
+			failed++
+		} else {
+			log.Info(containerName, "/", snapshot, ": Dump ", manifest.Dump.Archive, " OK")
+		}
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%d of %d archives of %s/%s failed verification", failed, total, containerName, snapshot)
+	}
+
+	return nil
+}
+
+func verifyArchive(snapshotPath, archiveName string, expectedSize int64, expectedSHA256, backupErr string) error {
+	if backupErr != "" {
+		return errors.New("Archive " + archiveName + " failed during backup: " + backupErr)
+	}
+
+	sha, size, err := hashObject(snapshotPath + archiveName)
+	if err != nil {
+		return errors.New("Error reading archive " + archiveName + ": " + err.Error())
+	}
+	if size != expectedSize || sha != expectedSHA256 {
+		return fmt.Errorf("Archive %s is corrupted! Expected %d bytes sha256 %s, got %d bytes sha256 %s", archiveName, expectedSize, expectedSHA256, size, sha)
+	}
+
+	return nil
+}
+
+// verifySharedArchive verifies an archive of another snapshot against the manifest of that snapshot.
+func verifySharedArchive(sharedArchive string) error {
+	i := strings.LastIndex(sharedArchive, "/")
+	snapshotPath := sharedArchive[:i+1]
+	archiveName := sharedArchive[i+1:]
+
+	manifest, err := ReadManifest(snapshotPath)
+	if err != nil {
+		return errors.New("Error reading manifest of shared archive " + sharedArchive + ": " + err.Error())
+	}
+	for _, m := range manifest.Mounts {
+		if m.Archive == archiveName {
+			return verifyArchive(snapshotPath, m.Archive, m.Size, m.SHA256, m.BackupError())
+		}
+	}
+
+	return errors.New("Shared archive " + sharedArchive + " not found in its manifest")
+}
@@ -0,0 +1,381 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bata94/DockerRight/internal/config"
+	"github.com/bata94/DockerRight/internal/log"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+type ContainerInfo struct {
+	ID   string
+	Name string
+	// Identity is the compose service or the name, it stays the same if the container is recreated.
+	Identity     string
+	Image        string
+	ImageID      string
+	States       []string
+	MonitorState string
+	// ProbeError is the error of the last failed probe, empty if it succeeded or there is no probe.
+	ProbeError string
+
+	// Details of the last inspect
+	ExitCode     int
+	OOMKilled    bool
+	RestartCount int
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	// NewExit is true if the container exited since the last inspect, until it is notified.
+	NewExit bool
+	// Restarts are the start times within the MonitorRestartWindowMinutes, RestartLoop is true while
+	// the container restarted more than MonitorRestartLimit times in it.
+	Restarts    []time.Time
+	RestartLoop bool
+	// Transitions are the times of the up/down state changes within the MonitorFlapWindowMinutes,
+	// Flapping is true while there were at least MonitorFlapThreshold of them.
+	Transitions []time.Time
+	Flapping    bool
+
+	// Stats of the last poll, nil if the container isn't running or has no thresholds
+	Stats      *ContainerStats `json:"-"`
+	Thresholds map[string]*ThresholdState
+
+	// LastAlert is the time of the last notification about the container, it is persisted,
+	// so events replayed after a restart of DockerRight are not notified again (see AlertEvent).
+	LastAlert time.Time
+}
+
+// monitorPopulated is true once the monitor knows the existing containers, only containers created after are notified as added.
+var monitorPopulated bool
+
+// monitorState is the monitor state persisted across restarts.
+type monitorState struct {
+	SavedAt    time.Time
+	Containers []ContainerInfo
+}
+
+// Alert sends a monitor notification about the container.
+func (ci *ContainerInfo) Alert(msg ...interface{}) {
+	ci.LastAlert = time.Now()
+	log.MonitorMsg(msg...)
+}
+
+// AlertEvent sends a monitor notification about an event. A replayed event is not notified, if there was a notification
+// about the container after it: the events are handled in order, so it was handled before DockerRight went down.
+func (ci *ContainerInfo) AlertEvent(ev ContainerEvent, msg ...interface{}) {
+	if ev.Replayed && ev.Time.Before(ci.LastAlert) {
+		log.Info("Not notifying replayed event ", ev.Action, " of ", ci.Name, " again, last notified at ", ci.LastAlert.Format(time.DateTime))
+		return
+	}
+	ci.Alert(msg...)
+}
+
+// MonitorContainers polls all monitored containers, appends their current state and reads their details.
+func MonitorContainers(contInfos *[]ContainerInfo) error {
+	log.Info("MonitorContainers")
+
+	c, err := syncContainers(contInfos)
+	if err != nil {
+		return err
+	}
+
+	for _, container := range c {
+		var ci *ContainerInfo
+		for i := range *contInfos {
+			if container.ID == (*contInfos)[i].ID {
+				ci = &(*contInfos)[i]
+				break
+			}
+		}
+		if ci == nil {
+			continue
+		}
+		// The pause/stop for a backup is not a state change
+		if isQuiesced(ci.ID, time.Now()) {
+			log.Debug("Skipping container ", ci.Name, ", it is quiesced for a backup")
+			continue
+		}
+
+		settings := GetContainerSettings(container.Names[0], container.Labels)
+		contState := container.State
+		if strings.Contains(container.Status, "unhealthy") {
+			contState = "unhealthy"
+		}
+
+		// A failed probe makes the container unhealthy, like a failed HEALTHCHECK
+		ci.ProbeError = ""
+		if container.State == "running" && settings.MonitorProbe != nil {
+			err := runProbe(container, *settings.MonitorProbe)
+			if err != nil {
+				log.Info("Probe of container ", ci.Name, " failed: ", err)
+				ci.ProbeError = err.Error()
+				contState = "unhealthy"
+			}
+		}
+		ci.States = append(ci.States, contState)
+
+		err := InspectContainer(ci)
+		if err != nil {
+			log.Error("Error inspecting container ", ci.Name, ": ", err)
+		}
+
+		if container.State != "running" || !settings.hasThresholds() {
+			// Usage has to be sustained again after a restart
+			ci.Stats = nil
+			for _, t := range ci.Thresholds {
+				t.Since = time.Time{}
+			}
+			continue
+		}
+		err = updateStats(ci)
+		if err != nil {
+			log.Error("Error reading stats of container ", ci.Name, ": ", err)
+			continue
+		}
+		checkThresholds(ci, settings)
+	}
+
+	return nil
+}
+
+// SyncContainers matches the monitor state with the current containers, see syncContainers.
+func SyncContainers(contInfos *[]ContainerInfo) error {
+	_, err := syncContainers(contInfos)
+	return err
+}
+
+// syncContainers matches the monitor state with the current containers and returns them.
+// Containers are tracked by their identity (compose service or name), so a recreated container takes over the state
+// of its predecessor. Added and removed containers are notified, except on the first sync without a persisted state.
+func syncContainers(contInfos *[]ContainerInfo) ([]types.Container, error) {
+	c, err := cli.ContainerList(ctx, container.ListOptions{
+		All: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exists := map[string]types.Container{}
+	for _, ctr := range c {
+		exists[ctr.ID] = ctr
+	}
+
+	monitored := []types.Container{}
+	for _, ctr := range c {
+		if skipContainer(ctr) {
+			continue
+		}
+		if !GetContainerSettings(ctr.Names[0], ctr.Labels).MonitorEnable {
+			*contInfos = slices.DeleteFunc(*contInfos, func(ci ContainerInfo) bool { return ci.ID == ctr.ID })
+			continue
+		}
+		monitored = append(monitored, ctr)
+	}
+
+	tracked := map[string]bool{}
+	for _, ctr := range monitored {
+		i := slices.IndexFunc(*contInfos, func(ci ContainerInfo) bool { return ci.ID == ctr.ID })
+		if i != -1 {
+			(*contInfos)[i].Name = ctr.Names[0][1:]
+			(*contInfos)[i].Identity = containerIdentity(ctr)
+			tracked[ctr.ID] = true
+		}
+	}
+
+	for _, ctr := range monitored {
+		if tracked[ctr.ID] {
+			continue
+		}
+		identity := containerIdentity(ctr)
+		i := slices.IndexFunc(*contInfos, func(ci ContainerInfo) bool { return ci.Identity == identity })
+
+		if i == -1 {
+			*contInfos = append(*contInfos, ContainerInfo{ID: ctr.ID, Name: ctr.Names[0][1:], Identity: identity})
+			tracked[ctr.ID] = true
+			if monitorPopulated {
+				(*contInfos)[len(*contInfos)-1].Alert("Container ", ctr.Names[0][1:], " was added (", ctr.Image, ")")
+			}
+			continue
+		}
+
+		// The newer container takes over, i.e. compose creates the new one before it removes the old one
+		ci := &(*contInfos)[i]
+		if prev, ok := exists[ci.ID]; ok && prev.Created > ctr.Created {
+			continue
+		}
+		log.Info("Container ", identity, " was recreated: ", ci.ID, " -> ", ctr.ID)
+		ci.ID = ctr.ID
+		ci.Name = ctr.Names[0][1:]
+		// Restart detection starts over, the image is compared on the next inspect
+		ci.StartedAt = time.Time{}
+		ci.FinishedAt = time.Time{}
+		ci.RestartCount = 0
+		ci.NewExit = false
+		ci.Stats = nil
+		tracked[ctr.ID] = true
+	}
+
+	*contInfos = slices.DeleteFunc(*contInfos, func(ci ContainerInfo) bool {
+		if tracked[ci.ID] {
+			return false
+		}
+		if _, ok := exists[ci.ID]; !ok {
+			ci.Alert("Container ", ci.Name, " was removed")
+		}
+		return true
+	})
+	monitorPopulated = true
+
+	return monitored, nil
+}
+
+// containerIdentity identifies a container across recreations, its compose service (and replica number) or its name.
+func containerIdentity(ctr types.Container) string {
+	project, service := ctr.Labels["com.docker.compose.project"], ctr.Labels["com.docker.compose.service"]
+	if project == "" || service == "" {
+		return strings.TrimPrefix(ctr.Names[0], "/")
+	}
+	identity := project + "/" + service
+	if number := ctr.Labels["com.docker.compose.container-number"]; number != "" && number != "1" {
+		identity += "/" + number
+	}
+	return identity
+}
+
+// InspectContainer updates the exit and restart details of ci. Restarts since the last inspect are recorded
+// (the restart count of the restart policy or a newer start time) and NewExit is set for a newer finish time.
+// Containers quiesced for a backup are not inspected, the first inspect after they were resumed only takes the new details.
+func InspectContainer(ci *ContainerInfo) error {
+	if isQuiesced(ci.ID, time.Now()) {
+		return nil
+	}
+	info, err := cli.ContainerInspect(ctx, ci.ID)
+	if err != nil {
+		return err
+	}
+
+	startedAt, _ := time.Parse(time.RFC3339Nano, info.State.StartedAt)
+	finishedAt, _ := time.Parse(time.RFC3339Nano, info.State.FinishedAt)
+	now := time.Now()
+	_, resumed := rebaseline.LoadAndDelete(ci.ID)
+
+	// Nothing to compare on the first inspect
+	if !ci.StartedAt.IsZero() && !resumed {
+		restarts := info.RestartCount - ci.RestartCount
+		if restarts < 1 && startedAt.After(ci.StartedAt) {
+			restarts = 1
+		}
+		for j := 0; j < restarts; j++ {
+			ci.Restarts = append(ci.Restarts, now)
+		}
+		if finishedAt.After(ci.FinishedAt) {
+			ci.NewExit = true
+		}
+	}
+
+	window := now.Add(-time.Duration(config.Conf.MonitorRestartWindowMinutes) * time.Minute)
+	for len(ci.Restarts) > 0 && ci.Restarts[0].Before(window) {
+		ci.Restarts = ci.Restarts[1:]
+	}
+
+	// The image of a container can't change, a different one means it was recreated
+	if ci.ImageID != "" && ci.ImageID != info.Image {
+		ci.Alert("Container ", ci.Name, " was recreated with a new image ", info.Config.Image, " (", shortID(ci.ImageID), " -> ", shortID(info.Image), ")")
+	}
+	ci.Image = info.Config.Image
+	ci.ImageID = info.Image
+
+	ci.ExitCode = info.State.ExitCode
+	ci.OOMKilled = info.State.OOMKilled
+	ci.RestartCount = info.RestartCount
+	ci.StartedAt = startedAt
+	ci.FinishedAt = finishedAt
+
+	return nil
+}
+
+// ExitDetails describes the last exit of the container, i.e. "exit code 137, OOM killed, restarted 4 times".
+func (ci ContainerInfo) ExitDetails() string {
+	details := fmt.Sprint("exit code ", ci.ExitCode)
+	if ci.OOMKilled {
+		details += ", OOM killed"
+	}
+	if ci.RestartCount > 0 {
+		details += fmt.Sprint(", restarted ", ci.RestartCount, " times")
+	}
+	if !ci.StartedAt.IsZero() {
+		details += ", last started " + ci.StartedAt.Local().Format(time.DateTime)
+	}
+	return details
+}
+
+func monitorStatePath() string {
+	return filepath.Dir(config.ConfigPath) + "/monitor-state.json"
+}
+
+// SaveMonitorState persists the monitor state next to the config, so a restart of DockerRight doesn't forget
+// which containers were already notified as down.
+func SaveMonitorState(contInfos []ContainerInfo) error {
+	state, err := json.Marshal(monitorState{SavedAt: time.Now(), Containers: contInfos})
+	if err != nil {
+		return err
+	}
+
+	// Written to a temp file first, so a crash never leaves a broken state file
+	path := monitorStatePath()
+	err = os.WriteFile(path+".tmp", state, 0o600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// LoadMonitorState returns the persisted monitor state and the time it was saved (zero if there is none),
+// synced with the current containers.
+func LoadMonitorState() ([]ContainerInfo, time.Time) {
+	contInfos := []ContainerInfo{}
+
+	stateFile, err := os.ReadFile(monitorStatePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("Error reading monitor state, starting without: ", err)
+		}
+		return contInfos, time.Time{}
+	}
+	var state monitorState
+	err = json.Unmarshal(stateFile, &state)
+	if err != nil {
+		log.Error("Error parsing monitor state, starting without: ", err)
+		return contInfos, time.Time{}
+	}
+
+	contInfos = state.Containers
+	log.Info("Loaded monitor state of ", len(contInfos), " containers, saved at ", state.SavedAt.Format(time.DateTime))
+
+	// Containers added, removed or recreated while DockerRight was down are notified
+	monitorPopulated = true
+	err = SyncContainers(&contInfos)
+	if err != nil {
+		log.Error("Error syncing monitor state with the containers: ", err)
+	}
+
+	return contInfos, state.SavedAt
+}
+
+// shortID shortens an image or container ID like the docker CLI, i.e. "sha256:4f3c...".
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,38 @@
+package docker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlertEvent(t *testing.T) {
+	lastAlert := time.Date(2024, 3, 15, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name     string
+		ev       ContainerEvent
+		wantSent bool
+	}{
+		{"live event", ContainerEvent{Action: "oom", Time: lastAlert.Add(-time.Minute)}, true},
+		{"replayed event after the last alert", ContainerEvent{Action: "oom", Time: lastAlert.Add(time.Minute), Replayed: true}, true},
+		{"replayed event before the last alert", ContainerEvent{Action: "oom", Time: lastAlert.Add(-time.Minute), Replayed: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ci := ContainerInfo{Name: "app", LastAlert: lastAlert}
+			ci.AlertEvent(tt.ev, "app ran out of memory")
+			if sent := ci.LastAlert.After(lastAlert); sent != tt.wantSent {
+				t.Errorf("AlertEvent() sent = %v, want %v", sent, tt.wantSent)
+			}
+		})
+	}
+
+	t.Run("no alert before", func(t *testing.T) {
+		ci := ContainerInfo{Name: "app"}
+		ci.AlertEvent(ContainerEvent{Action: "oom", Time: lastAlert, Replayed: true}, "app ran out of memory")
+		if ci.LastAlert.IsZero() {
+			t.Error("AlertEvent() of a replayed event without an alert before was not sent")
+		}
+	})
+}
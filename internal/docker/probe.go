@@ -0,0 +1,116 @@
+package docker
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bata94/DockerRight/internal/config"
+
+	"github.com/docker/docker/api/types"
+)
+
+var (
+	ownNetworksOnce sync.Once
+	// ownNetworks are the networks of the container DockerRight runs in, their addresses are reachable for probes.
+	ownNetworks map[string]bool
+)
+
+// runProbe checks a running container with an HTTP or TCP probe, the error describes why it failed.
+func runProbe(ctr types.Container, p config.Probe) error {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return err
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		host, err = containerAddress(ctr)
+		if err != nil {
+			return err
+		}
+	}
+	port := u.Port()
+	if port == "" && u.Scheme == "https" {
+		port = "443"
+	} else if port == "" {
+		port = "80"
+	}
+	u.Host = net.JoinHostPort(host, port)
+	timeout := time.Duration(config.Conf.MonitorProbeTimeoutSeconds) * time.Second
+
+	if u.Scheme == "tcp" {
+		conn, err := net.DialTimeout("tcp", u.Host, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if p.Status != 0 && resp.StatusCode != p.Status {
+		return errors.New(u.String() + " returned status " + strconv.Itoa(resp.StatusCode) + ", expected " + strconv.Itoa(p.Status))
+	}
+	if p.Status == 0 && (resp.StatusCode < 200 || resp.StatusCode > 399) {
+		return errors.New(u.String() + " returned status " + strconv.Itoa(resp.StatusCode))
+	}
+	if p.Body != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+		if err != nil {
+			return errors.New("Error reading response of " + u.String() + ": " + err.Error())
+		}
+		// Validated with the config/label
+		if !regexp.MustCompile(p.Body).Match(body) {
+			return errors.New("Response of " + u.String() + " doesn't match '" + p.Body + "'")
+		}
+	}
+
+	return nil
+}
+
+// containerAddress returns the IP of the container, preferring networks DockerRight is attached to as well.
+func containerAddress(ctr types.Container) (string, error) {
+	ownNetworksOnce.Do(func() {
+		ownNetworks = map[string]bool{}
+		if ownContainerID == "" {
+			return
+		}
+		info, err := cli.ContainerInspect(ctx, ownContainerID)
+		if err == nil && info.NetworkSettings != nil {
+			for name := range info.NetworkSettings.Networks {
+				ownNetworks[name] = true
+			}
+		}
+	})
+
+	if ctr.NetworkSettings == nil {
+		return "", errors.New("Container has no network address")
+	}
+	address := ""
+	for name, n := range ctr.NetworkSettings.Networks {
+		if n == nil || n.IPAddress == "" {
+			continue
+		}
+		if ownNetworks[name] {
+			return n.IPAddress, nil
+		}
+		address = n.IPAddress
+	}
+	if address == "" {
+		return "", errors.New("Container has no network address, set a host in the probe URL")
+	}
+
+	return address, nil
+}
@@ -0,0 +1,187 @@
+package docker
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bata94/DockerRight/internal/config"
+	"github.com/bata94/DockerRight/internal/log"
+	"github.com/bata94/DockerRight/internal/retention"
+	"github.com/bata94/DockerRight/internal/storage"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// PruneResult is the retention decision for a single snapshot in a target.
+type PruneResult struct {
+	Target    string
+	Container string
+	Snapshot  string
+	Keep      bool
+	Reasons   []string
+}
+
+// Prune runs PruneBackups for the prune command. Unless dryRun it holds the run lock,
+// so no snapshot is removed while a backup run writes an incremental on top of it.
+func Prune(dryRun bool) ([]PruneResult, error) {
+	if !dryRun {
+		unlock, err := lockRun("prune")
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	return PruneBackups(dryRun)
+}
+
+// PruneBackups applies the retention policy of every container to its snapshots in every target and removes the pruned ones.
+// Targets with their own RetentionPolicy use it for all containers.
+// With dryRun nothing is removed, the results list what would be pruned and why.
+// Pruned snapshots are kept if an incremental or a shared archive of a kept snapshot depends on them.
+func PruneBackups(dryRun bool) ([]PruneResult, error) {
+	policies := map[string]retention.Policy{}
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		log.Error("Error listing containers, using global RetentionPolicy for all backups: ", err)
+	}
+	for _, ctr := range containers {
+		policies[strings.TrimPrefix(ctr.Names[0], "/")] = GetContainerSettings(ctr.Names[0], ctr.Labels).RetentionPolicy
+	}
+
+	all := []PruneResult{}
+	var pruneErr error
+	for _, t := range targets {
+		results, err := pruneTarget(t, policies, dryRun)
+		if err != nil {
+			log.Error("Error pruning target ", t.Name, ": ", err)
+			pruneErr = err
+			continue
+		}
+		all = append(all, results...)
+	}
+
+	return all, pruneErr
+}
+
+func pruneTarget(t target, policies map[string]retention.Policy, dryRun bool) ([]PruneResult, error) {
+	containerDirs, err := storage.ListDirs(t.Store, "")
+	if err != nil {
+		log.Error("Error reading backup path: ", err)
+		return nil, err
+	}
+
+	log.Info("Found ", len(containerDirs), " containerDirs in target ", t.Name, ":", "\n", containerDirs)
+
+	results := map[string][]PruneResult{}
+	for _, c := range containerDirs {
+		snapshots, err := listSnapshots(t.Store, c)
+		if err != nil {
+			log.Error("Error reading backup path: ", err)
+			continue
+		}
+
+		policy, ok := policies[c]
+		if !ok {
+			policy = config.Conf.Retention()
+		}
+		if t.Retention != nil {
+			policy = *t.Retention
+		}
+		log.Info("ContainerDir: ", c, " RetentionPolicy: ", policy)
+
+		times := []time.Time{}
+		for _, s := range snapshots {
+			t, err := time.ParseInLocation("2006-01-02-15-04-05", s, time.Local)
+			if err != nil {
+				log.Error("Error parsing backup time: ", err)
+				continue
+			}
+			times = append(times, t)
+		}
+
+		for _, d := range policy.Apply(times, time.Now()) {
+			results[c] = append(results[c], PruneResult{
+				Target:    t.Name,
+				Container: c,
+				Snapshot:  d.Time.Format("2006-01-02-15-04-05"),
+				Keep:      d.Keep,
+				Reasons:   d.Reasons,
+			})
+		}
+	}
+
+	// Shared archives live in the snapshot of the owning container, keep them as long as any kept snapshot references them
+	for _, containerResults := range results {
+		for _, r := range containerResults {
+			if !r.Keep {
+				continue
+			}
+			manifest, err := readManifest(t.Store, snapshotKey(r.Container, r.Snapshot))
+			if err != nil {
+				continue
+			}
+			for _, mm := range manifest.Mounts {
+				if mm.SharedArchive == "" {
+					continue
+				}
+				parts := strings.Split(mm.SharedArchive, "/")
+				if len(parts) < 2 {
+					continue
+				}
+				keepResult(results[parts[0]], parts[1], "shared archive of "+r.Container+" "+r.Snapshot)
+			}
+		}
+	}
+
+	containerNames := []string{}
+	for containerName := range results {
+		containerNames = append(containerNames, containerName)
+	}
+	sort.Strings(containerNames)
+
+	all := []PruneResult{}
+	for _, containerName := range containerNames {
+		containerResults := results[containerName]
+		containerPath := t.Name + ":" + containerName
+		kept := []string{}
+		for _, r := range containerResults {
+			if r.Keep {
+				kept = append(kept, r.Snapshot)
+			}
+		}
+		for base := range requiredBaseSnapshots(t.Store, containerName, kept) {
+			keepResult(containerResults, base, "base of an incremental backup")
+		}
+
+		for _, r := range containerResults {
+			all = append(all, r)
+			if r.Keep {
+				log.Info("Keeping ", containerPath+"/"+r.Snapshot, " (", strings.Join(r.Reasons, ", "), ")")
+				continue
+			}
+			if dryRun {
+				log.Info("Would remove ", containerPath+"/"+r.Snapshot)
+				continue
+			}
+			log.Info("Removing ", containerPath+"/"+r.Snapshot)
+			err = storage.DeletePrefix(t.Store, snapshotKey(containerName, r.Snapshot))
+			if err != nil {
+				log.Error("Error removing backup: ", err)
+				continue
+			}
+		}
+	}
+
+	return all, nil
+}
+
+func keepResult(results []PruneResult, snapshot, reason string) {
+	for i, r := range results {
+		if r.Snapshot == snapshot {
+			results[i].Keep = true
+			results[i].Reasons = append(results[i].Reasons, reason)
+		}
+	}
+}
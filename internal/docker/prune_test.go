@@ -0,0 +1,183 @@
+package docker
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bata94/DockerRight/internal/retention"
+	"github.com/bata94/DockerRight/internal/storage"
+)
+
+func TestPruneTarget(t *testing.T) {
+	now := time.Now()
+	// snapshot returns the name of the snapshot created hours ago
+	snapshot := func(hours int) string {
+		return now.Add(-time.Duration(hours) * time.Hour).Format("2006-01-02-15-04-05")
+	}
+	day := map[string]retention.Policy{"app": {Within: 24}, "worker": {Within: 24}}
+
+	tests := []struct {
+		name      string
+		snapshots map[string]map[string][]ManifestMount
+		policies  map[string]retention.Policy
+		// targetRetention overrides the policies, like the RetentionPolicy of a BackupTarget
+		targetRetention *retention.Policy
+		dryRun          bool
+		// want are the snapshots left per container
+		want map[string][]string
+		// wantKept are the snapshots expected to be kept per container, want if nil
+		wantKept map[string][]string
+	}{
+		{
+			name: "full backups outside retention",
+			snapshots: map[string]map[string][]ManifestMount{"app": {
+				snapshot(72): {full()},
+				snapshot(48): {full()},
+				snapshot(1):  {full()},
+			}},
+			policies: day,
+			want:     map[string][]string{"app": {snapshot(1)}},
+		},
+		{
+			name: "incremental chain with its base outside retention",
+			snapshots: map[string]map[string][]ManifestMount{"app": {
+				snapshot(96): {full()},
+				snapshot(72): {incremental(0, "")},
+				snapshot(48): {incremental(1, snapshot(72))},
+				snapshot(1):  {incremental(2, snapshot(48))},
+			}},
+			policies: day,
+			want:     map[string][]string{"app": {snapshot(72), snapshot(48), snapshot(1)}},
+		},
+		{
+			name: "chain outside retention after a new full backup",
+			snapshots: map[string]map[string][]ManifestMount{"app": {
+				snapshot(72): {incremental(0, "")},
+				snapshot(48): {incremental(1, snapshot(72))},
+				snapshot(2):  {incremental(0, "")},
+				snapshot(1):  {incremental(1, snapshot(2))},
+			}},
+			policies: day,
+			want:     map[string][]string{"app": {snapshot(2), snapshot(1)}},
+		},
+		{
+			name: "broken link",
+			snapshots: map[string]map[string][]ManifestMount{"app": {
+				snapshot(96): {incremental(0, "")},
+				snapshot(72): {incremental(1, snapshot(96))},
+				// The base at 48 hours was deleted by hand
+				snapshot(1): {incremental(3, snapshot(48))},
+			}},
+			policies: day,
+			want:     map[string][]string{"app": {snapshot(1)}},
+		},
+		{
+			name: "shared archive referenced only by a kept sharer",
+			snapshots: map[string]map[string][]ManifestMount{
+				"app":    {snapshot(48): {full()}, snapshot(1): {full()}},
+				"worker": {snapshot(48): {shared("app", snapshot(48))}, snapshot(1): {shared("app", snapshot(1))}},
+			},
+			policies: map[string]retention.Policy{"app": {Within: 24}, "worker": {Within: 72}},
+			want: map[string][]string{
+				"app":    {snapshot(48), snapshot(1)},
+				"worker": {snapshot(48), snapshot(1)},
+			},
+		},
+		{
+			name: "shared incremental archive referenced only by a kept sharer",
+			snapshots: map[string]map[string][]ManifestMount{
+				"app":    {snapshot(72): {incremental(0, "")}, snapshot(48): {incremental(1, snapshot(72))}, snapshot(1): {incremental(0, "")}},
+				"worker": {snapshot(48): {shared("app", snapshot(48))}},
+			},
+			policies: map[string]retention.Policy{"app": {Within: 24}, "worker": {Within: 72}},
+			want: map[string][]string{
+				"app":    {snapshot(72), snapshot(48), snapshot(1)},
+				"worker": {snapshot(48)},
+			},
+		},
+		{
+			name: "shared archive of a pruned sharer",
+			snapshots: map[string]map[string][]ManifestMount{
+				"app":    {snapshot(48): {full()}, snapshot(1): {full()}},
+				"worker": {snapshot(48): {shared("app", snapshot(48))}, snapshot(1): {shared("app", snapshot(1))}},
+			},
+			policies: day,
+			want: map[string][]string{
+				"app":    {snapshot(1)},
+				"worker": {snapshot(1)},
+			},
+		},
+		{
+			name: "target retention",
+			snapshots: map[string]map[string][]ManifestMount{"app": {
+				snapshot(48): {full()},
+				snapshot(1):  {full()},
+			}},
+			policies:        map[string]retention.Policy{"app": {Within: 72}},
+			targetRetention: &retention.Policy{Within: 24},
+			want:            map[string][]string{"app": {snapshot(1)}},
+		},
+		{
+			name: "dry run",
+			snapshots: map[string]map[string][]ManifestMount{"app": {
+				snapshot(48): {full()},
+				snapshot(1):  {full()},
+			}},
+			policies: day,
+			dryRun:   true,
+			want:     map[string][]string{"app": {snapshot(48), snapshot(1)}},
+			wantKept: map[string][]string{"app": {snapshot(1)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := storage.NewLocal(t.TempDir())
+			for containerName, snapshots := range tt.snapshots {
+				for s, mounts := range snapshots {
+					putSnapshot(t, b, containerName, s, mounts...)
+				}
+			}
+
+			results, err := pruneTarget(target{Name: "test", Store: b, Retention: tt.targetRetention}, tt.policies, tt.dryRun)
+			if err != nil {
+				t.Fatalf("pruneTarget() error = %v", err)
+			}
+
+			wantKept := tt.wantKept
+			if wantKept == nil {
+				wantKept = tt.want
+			}
+			kept := map[string][]string{}
+			for _, r := range results {
+				if r.Keep {
+					kept[r.Container] = append(kept[r.Container], r.Snapshot)
+				}
+			}
+			for containerName, want := range wantKept {
+				if got := sortedStrings(kept[containerName]); !reflect.DeepEqual(got, sortedStrings(want)) {
+					t.Errorf("kept snapshots of %s = %v, want %v", containerName, got, sortedStrings(want))
+				}
+			}
+
+			for containerName, want := range tt.want {
+				got, err := listSnapshots(b, containerName)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, sortedStrings(want)) {
+					t.Errorf("snapshots of %s left = %v, want %v", containerName, got, sortedStrings(want))
+				}
+			}
+		})
+	}
+}
+
+// sortedStrings returns a sorted copy of s, snapshot names sort by time.
+func sortedStrings(s []string) []string {
+	sorted := append([]string{}, s...)
+	sort.Strings(sorted)
+	return sorted
+}
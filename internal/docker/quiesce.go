@@ -0,0 +1,122 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bata94/DockerRight/internal/config"
+	"github.com/bata94/DockerRight/internal/log"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	QuiesceNone  = "none"
+	QuiescePause = "pause"
+	QuiesceStop  = "stop"
+)
+
+// quiesceRequestMargin is added to BackupQuiesceTimeoutSeconds for the pause/stop/start requests.
+const quiesceRequestMargin = 30 * time.Second
+
+func ValidQuiesceMode(mode string) bool {
+	switch strings.ToLower(mode) {
+	case QuiesceNone, QuiescePause, QuiesceStop, "":
+		return true
+	default:
+		return false
+	}
+}
+
+// quiesceContainer pauses or stops a running container for a consistent snapshot.
+// The returned resume func unpauses/starts it again, it is safe to call it more than once.
+func quiesceContainer(ctr types.Container, mode string) (func(), error) {
+	noop := func() {}
+	mode = strings.ToLower(mode)
+	if mode == QuiesceNone || mode == "" {
+		return noop, nil
+	}
+	if ctr.State != "running" {
+		log.Debug("Container ", ctr.Names[0], " is not running, no need to ", mode, " it")
+		return noop, nil
+	}
+
+	// The requests need longer than the grace period of the stop, Docker kills the container after it
+	timeout := time.Duration(config.Conf.BackupQuiesceTimeoutSeconds)*time.Second + quiesceRequestMargin
+	tCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// The monitor must not alert on the pause/stop
+	quiesced.Store(ctr.ID, time.Time{})
+
+	switch mode {
+	case QuiescePause:
+		log.Info("Pausing container ", ctr.Names[0])
+		err := cli.ContainerPause(tCtx, ctr.ID)
+		if err != nil {
+			quiesced.Delete(ctr.ID)
+			return noop, errors.New("Error pausing container: " + err.Error())
+		}
+	case QuiesceStop:
+		log.Info("Stopping container ", ctr.Names[0])
+		stopTimeout := config.Conf.BackupQuiesceTimeoutSeconds
+		err := cli.ContainerStop(tCtx, ctr.ID, container.StopOptions{Timeout: &stopTimeout})
+		if err != nil {
+			// A failed stop might have stopped the container anyway
+			resumeContainer(ctr, mode, timeout)
+			return noop, errors.New("Error stopping container: " + err.Error())
+		}
+	default:
+		quiesced.Delete(ctr.ID)
+		return noop, errors.New("Unknown quiesce mode: " + mode)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			resumeContainer(ctr, mode, timeout)
+		})
+	}, nil
+}
+
+// resumeContainer unpauses/starts a quiesced container and waits until it is running again,
+// a MonitorMsg is sent if it doesn't come back within timeout.
+func resumeContainer(ctr types.Container, mode string, timeout time.Duration) {
+	tCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	defer func() {
+		rebaseline.Store(ctr.ID, true)
+		quiesced.Store(ctr.ID, time.Now())
+	}()
+
+	var err error
+	if mode == QuiescePause {
+		log.Info("Unpausing container ", ctr.Names[0])
+		err = cli.ContainerUnpause(tCtx, ctr.ID)
+	} else {
+		log.Info("Starting container ", ctr.Names[0])
+		err = cli.ContainerStart(tCtx, ctr.ID, container.StartOptions{})
+	}
+	if err != nil {
+		log.Error("Error resuming container ", ctr.Names[0], ": ", err)
+	}
+
+	for {
+		info, err := cli.ContainerInspect(tCtx, ctr.ID)
+		if err == nil && info.State.Running && !info.State.Paused {
+			log.Info("Container ", ctr.Names[0], " is running again")
+			return
+		}
+
+		select {
+		case <-tCtx.Done():
+			log.MonitorMsg(ctr.Names[0][1:], " did not come back after backup (", mode, ")! Please check it!")
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bata94/DockerRight/internal/archive"
+	"github.com/bata94/DockerRight/internal/config"
+	"github.com/bata94/DockerRight/internal/log"
+	"github.com/bata94/DockerRight/internal/storage"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// FindContainer returns the container with the given name, the leading "/" is optional.
+func FindContainer(name string) (types.Container, error) {
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return types.Container{}, err
+	}
+
+	name = "/" + strings.TrimPrefix(name, "/")
+	for _, ctr := range containers {
+		for _, n := range ctr.Names {
+			if n == name {
+				return ctr, nil
+			}
+		}
+	}
+
+	return types.Container{}, errors.New("Container not found: " + name)
+}
+
+// ListSnapshots returns all snapshot timestamps of a container, oldest first.
+func ListSnapshots(containerName string) ([]string, error) {
+	return listSnapshots(store, containerName)
+}
+
+func listSnapshots(b storage.Backend, containerName string) ([]string, error) {
+	containerName = strings.TrimPrefix(containerName, "/")
+	dirs, err := storage.ListDirs(b, containerName+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []string{}
+	for _, d := range dirs {
+		_, err := time.Parse("2006-01-02-15-04-05", d)
+		if err != nil {
+			log.Debug("Skipping non snapshot dir: ", d)
+			continue
+		}
+		snapshots = append(snapshots, d)
+	}
+	sort.Strings(snapshots)
+
+	return snapshots, nil
+}
+
+// RestoreContainer extracts the archives of a snapshot back into the mounts of a container.
+// snapshot may be "latest", if no mounts (destinations) are given every archived mount is restored.
+func RestoreContainer(containerName, snapshot string, mounts ...string) error {
+	containerName = strings.TrimPrefix(containerName, "/")
+	log.Info("RestoreContainer ", containerName, " Snapshot: ", snapshot)
+
+	ctr, err := FindContainer(containerName)
+	if err != nil {
+		return err
+	}
+
+	// A restore must not overlap with a backup run, of this or another DockerRight instance
+	unlock, err := lockRun("restore of " + containerName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	snapshotPath, err := resolveSnapshot(containerName, snapshot)
+	if err != nil {
+		return err
+	}
+
+	restoreMounts := []types.MountPoint{}
+	for _, m := range ctr.Mounts {
+		if len(mounts) != 0 && !containsString(mounts, m.Destination) {
+			continue
+		}
+		if _, err := findMountArchive(snapshotPath, m); err != nil {
+			log.Warn("No archive for mount ", m.Destination, " in snapshot ", snapshotPath, ", skipping!")
+			continue
+		}
+		restoreMounts = append(restoreMounts, m)
+	}
+	if len(restoreMounts) == 0 {
+		return errors.New("Nothing to restore for container " + containerName + " in snapshot " + snapshotPath)
+	}
+
+	wasRunning := ctr.State == "running"
+	if wasRunning {
+		log.Info("Stopping container ", containerName)
+		err = cli.ContainerStop(ctx, ctr.ID, container.StopOptions{})
+		if err != nil {
+			return errors.New("Error stopping container: " + err.Error())
+		}
+		defer func() {
+			log.Info("Starting container ", containerName)
+			err := cli.ContainerStart(ctx, ctr.ID, container.StartOptions{})
+			if err != nil {
+				log.Error("Error starting container ", containerName, " after restore: ", err)
+			}
+		}()
+	}
+
+	containerNameBase := "DockerRight-RestoreRunner-" + containerName
+	for i, m := range restoreMounts {
+		helperName := fmt.Sprint(containerNameBase, "-m", i, "-", strings.ReplaceAll(m.Destination, "/", "_"))
+		chain, incremental, err := archiveChain(snapshotPath, m)
+		if err != nil {
+			return err
+		}
+
+		// Incrementals are extracted on top of their base, oldest first
+		for j, archivePath := range chain {
+			log.Info(fmt.Sprintf("Restoring %s from %s", m.Destination, archivePath))
+
+			out, err := restoreMount(ctr, fmt.Sprint(helperName, "-", j), archivePath, incremental)
+			if err != nil {
+				return err
+			}
+			log.Debug("Restore output:", "\n", string(out))
+		}
+	}
+
+	log.Info("RestoreContainer ", containerName, " done")
+
+	return nil
+}
+
+// resolveSnapshot returns the key prefix of a stored snapshot, snapshot may be "latest".
+func resolveSnapshot(containerName, snapshot string) (string, error) {
+	if snapshot == "" || snapshot == "latest" {
+		snapshots, err := ListSnapshots(containerName)
+		if err != nil {
+			return "", err
+		}
+		if len(snapshots) == 0 {
+			return "", errors.New("No snapshots found for container " + containerName)
+		}
+		snapshot = snapshots[len(snapshots)-1]
+		log.Info("Using latest snapshot: ", snapshot)
+	}
+
+	if !snapshotExists(store, containerName, snapshot) {
+		return "", errors.New("Snapshot " + snapshot + " of container " + containerName + " not found in " + store.String())
+	}
+
+	return snapshotKey(containerName, snapshot), nil
+}
+
+// findMountArchive returns the key of the archive of a mount inside a snapshot, regardless of its compression.
+func findMountArchive(snapshotPath string, m types.MountPoint) (string, error) {
+	for _, ext := range archive.Extensions {
+		for _, encExt := range []string{"", archive.EncryptionExtension} {
+			archivePath := snapshotPath + mountArchiveName(m) + ext + encExt
+			if _, err := store.Stat(archivePath); err == nil {
+				return archivePath, nil
+			}
+		}
+	}
+
+	// The mount might be shared with another container, which archived it
+	manifest, err := ReadManifest(snapshotPath)
+	if err == nil {
+		for _, mm := range manifest.Mounts {
+			if mm.Destination == m.Destination && mm.SharedArchive != "" {
+				if mm.BackupError() != "" {
+					return "", errors.New("Shared archive " + mm.SharedArchive + " of mount " + m.Destination + " failed: " + mm.BackupError())
+				}
+				if _, err := store.Stat(mm.SharedArchive); err == nil {
+					return mm.SharedArchive, nil
+				}
+				return "", errors.New("Shared archive " + mm.SharedArchive + " of mount " + m.Destination + " not found")
+			}
+		}
+	}
+
+	return "", errors.New("No archive found for mount " + m.Destination)
+}
+
+// openArchive opens a stored archive and returns the decrypted and decompressed content, close must be called when done.
+func openArchive(archivePath string) (io.Reader, func(), error) {
+	f, err := store.Get(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dec, err := archive.NewDecryptReader(f, archivePath, config.Conf.BackupEncryption())
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	r, err := archive.NewReader(dec, archivePath)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return r, func() {
+		r.Close()
+		f.Close()
+	}, nil
+}
+
+// restoreMount streams the (decrypted and decompressed) archive into a helper container, which extracts it into the mounts of ctr.
+// Incremental archives also delete files, which were removed since their base.
+func restoreMount(ctr types.Container, helperName, archivePath string, incremental bool) ([]byte, error) {
+	r, closeArchive, err := openArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer closeArchive()
+
+	cmd := []string{"tar", "xvf", "-", "-C", "/"}
+	if incremental {
+		cmd = []string{"tar", "--listed-incremental=/dev/null", "-xvf", "-", "-C", "/"}
+	}
+	log.Debug(cmd)
+	out, exitCode, err := RunContainerWithStatus(RunContainerParams{
+		ContainerName: helperName,
+		ImageName:     defImage,
+		Cmd:           cmd,
+		Remove:        true,
+		VolumesFrom:   []string{ctr.ID},
+		Stdin:         r,
+	})
+	if err != nil {
+		return out, err
+	}
+	if exitCode != 0 {
+		return out, fmt.Errorf("Error restoring %s, tar exited with code %d", archivePath, exitCode)
+	}
+
+	return out, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/bata94/DockerRight/internal/log"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// scheduleKey identifies a schedule group, all containers with the same BackupSchedule.
+func scheduleKey(s ContainerSettings) string {
+	return strings.Join(s.BackupSchedule, ";")
+}
+
+// BackupSchedules returns the schedule groups in use (see ParseSchedule), with the containers in each group.
+func BackupSchedules() (map[string][]string, error) {
+	allContainers, err := cli.ContainerList(context.Background(), container.ListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := map[string][]string{}
+	for _, ctr := range allContainers {
+		if skipContainer(ctr) {
+			continue
+		}
+		settings := GetContainerSettings(ctr.Names[0], ctr.Labels)
+		if !settings.BackupEnable || len(settings.BackupSchedule) == 0 {
+			continue
+		}
+		key := scheduleKey(settings)
+		schedules[key] = append(schedules[key], strings.TrimPrefix(ctr.Names[0], "/"))
+	}
+	return schedules, nil
+}
+
+// BackupContainersForSchedule backs up every container of a schedule group.
+func BackupContainersForSchedule(schedule string) error {
+	log.Info("BackupContainersForSchedule ", schedule)
+	return backupContainers(schedule, func(_ types.Container, s ContainerSettings) bool { return scheduleKey(s) == schedule })
+}
+
+// ParseSchedule parses the ";" separated cron expressions of a schedule group into a single schedule,
+// which fires once, even if multiple expressions match the same minute.
+func ParseSchedule(schedule string) (cron.Schedule, error) {
+	multi := multiSchedule{}
+	for _, spec := range strings.Split(schedule, ";") {
+		s, err := cron.ParseStandard(spec)
+		if err != nil {
+			return nil, errors.New("Invalid cron expression '" + spec + "': " + err.Error())
+		}
+		multi = append(multi, s)
+	}
+	return multi, nil
+}
+
+type multiSchedule []cron.Schedule
+
+func (m multiSchedule) Next(t time.Time) time.Time {
+	next := time.Time{}
+	for _, s := range m {
+		n := s.Next(t)
+		if next.IsZero() || (!n.IsZero() && n.Before(next)) {
+			next = n
+		}
+	}
+	return next
+}
+
+// CatchUpBackups backs up every container, which missed a scheduled backup since its last successful snapshot,
+// i.e. because the host was down at the scheduled time.
+func CatchUpBackups() error {
+	log.Info("CatchUpBackups")
+	now := time.Now()
+
+	return backupContainers("catch-up", func(ctr types.Container, s ContainerSettings) bool {
+		if len(s.BackupSchedule) == 0 || (len(ctr.Mounts) == 0 && GetDumpStrategy(ctr, s) == nil) {
+			return false
+		}
+		schedule, err := ParseSchedule(scheduleKey(s))
+		if err != nil {
+			log.Error("Container ", ctr.Names[0], ": ", err)
+			return false
+		}
+
+		last, ok := lastSuccessfulSnapshot(ctr.Names[0])
+		if !ok {
+			log.Info("Container ", ctr.Names[0], " has no successful snapshot, catching up")
+			return true
+		}
+		if missed := schedule.Next(last); missed.Before(now) {
+			log.Info("Container ", ctr.Names[0], " missed its backup at ", missed.Format(time.DateTime), ", last successful snapshot ", last.Format(time.DateTime), ", catching up")
+			return true
+		}
+		return false
+	})
+}
+
+// lastSuccessfulSnapshot returns the time of the newest snapshot without errors in its manifest.
+func lastSuccessfulSnapshot(containerName string) (time.Time, bool) {
+	snapshots, err := ListSnapshots(containerName)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		t, err := time.ParseInLocation("2006-01-02-15-04-05", snapshots[i], time.Local)
+		if err != nil {
+			continue
+		}
+		manifest, err := ReadManifest(snapshotKey(containerName, snapshots[i]))
+		if err != nil {
+			// Snapshots created before manifests existed
+			return t, true
+		}
+		failed := manifest.Dump != nil && manifest.Dump.Error != ""
+		for _, m := range manifest.Mounts {
+			failed = failed || m.BackupError() != ""
+		}
+		if !failed {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
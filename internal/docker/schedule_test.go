@@ -0,0 +1,41 @@
+package docker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	from := time.Date(2024, 3, 15, 10, 30, 0, 0, time.Local)
+
+	tests := []struct {
+		name     string
+		schedule string
+		want     time.Time
+		wantErr  bool
+	}{
+		{"single expression", "0 3 * * *", time.Date(2024, 3, 16, 3, 0, 0, 0, time.Local), false},
+		{"hourly", "5 * * * *", time.Date(2024, 3, 15, 11, 5, 0, 0, time.Local), false},
+		{"earliest of multiple", "0 3 * * *;45 10 * * *", time.Date(2024, 3, 15, 10, 45, 0, 0, time.Local), false},
+		{"same minute fires once", "0 12 * * *;0 12 * * *", time.Date(2024, 3, 15, 12, 0, 0, 0, time.Local), false},
+		{"descriptor", "@daily", time.Date(2024, 3, 16, 0, 0, 0, 0, time.Local), false},
+		{"invalid expression", "0 3 * *", time.Time{}, true},
+		{"one invalid of multiple", "0 3 * * *;nope", time.Time{}, true},
+		{"empty", "", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule(tt.schedule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSchedule(%q) error = %v, wantErr %v", tt.schedule, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := s.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", from, got, tt.want)
+			}
+		})
+	}
+}
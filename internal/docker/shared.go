@@ -0,0 +1,189 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bata94/DockerRight/internal/log"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+)
+
+// backupRun is the state of a single backup run over multiple containers.
+// All snapshots of a run share the same timestamp, so shared volumes can be referenced across containers.
+type backupRun struct {
+	Timestamp time.Time
+	// owners maps a volume/bind (see mountKey) to the container and mount which archives it
+	owners  map[string]sharedOwner
+	sharers map[string][]string
+
+	mu sync.Mutex
+	// stored and failed list the containers per target, whose snapshot was (not) stored
+	stored map[string][]string
+	failed map[string][]string
+
+	// archived has the result of the owner of every shared volume/bind, the sharers wait for it
+	archived map[string]*sharedResult
+	// waiting is the number of containers waiting for a shared archive, they don't count as concurrent backups
+	waiting atomic.Int64
+}
+
+// sharedResult is the result of archiving a shared volume/bind, done is closed once it is known.
+type sharedResult struct {
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+func (s *sharedResult) finish(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+type sharedOwner struct {
+	Container string
+	Mount     types.MountPoint
+	MaxAge    time.Duration
+}
+
+// newBackupRun assigns every volume/bind mounted by more than one container to a single owner,
+// the container with the longest retention, so the shared archive is kept as long as any snapshot referencing it.
+func newBackupRun(containers []types.Container) *backupRun {
+	run := &backupRun{
+		Timestamp: time.Now(),
+		owners:    map[string]sharedOwner{},
+		sharers:   map[string][]string{},
+		stored:    map[string][]string{},
+		failed:    map[string][]string{},
+		archived:  map[string]*sharedResult{},
+	}
+
+	for _, ctr := range containers {
+		name := strings.TrimPrefix(ctr.Names[0], "/")
+		settings := GetContainerSettings(ctr.Names[0], ctr.Labels)
+		for _, m := range ctr.Mounts {
+			if mountSkipReason(settings, m) != "" {
+				continue
+			}
+			key := mountKey(m)
+			if !containsString(run.sharers[key], name) {
+				run.sharers[key] = append(run.sharers[key], name)
+			}
+			owner, ok := run.owners[key]
+			if !ok || settings.RetentionPolicy.MaxAge() > owner.MaxAge {
+				run.owners[key] = sharedOwner{Container: name, Mount: m, MaxAge: settings.RetentionPolicy.MaxAge()}
+			}
+		}
+	}
+
+	for key, sharers := range run.sharers {
+		if len(sharers) > 1 {
+			run.archived[key] = &sharedResult{done: make(chan struct{})}
+			log.Info("Mount ", key, " is shared by ", sharers, ", it will be archived once by ", run.owners[key].Container)
+		}
+	}
+
+	return run
+}
+
+func (r *backupRun) Snapshot() string {
+	return r.Timestamp.Format("2006-01-02-15-04-05")
+}
+
+func (r *backupRun) recordStored(containerName string, results map[string]error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for targetName, err := range results {
+		if err != nil {
+			r.failed[targetName] = append(r.failed[targetName], containerName)
+		} else {
+			r.stored[targetName] = append(r.stored[targetName], containerName)
+		}
+	}
+}
+
+// logSummary sends a notification with the number of snapshots stored per target and the containers that failed.
+func (r *backupRun) logSummary() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := []string{}
+	for _, t := range targets {
+		result := fmt.Sprint(t.Name, ": ", len(r.stored[t.Name]), " snapshots stored")
+		if len(r.failed[t.Name]) != 0 {
+			result += fmt.Sprint(", FAILED for ", r.failed[t.Name])
+		}
+		results = append(results, result)
+	}
+	log.MonitorMsg("Backup run ", r.Snapshot(), " done (", strings.Join(results, "; "), ")")
+}
+
+// sharedOwner returns the owner of m, ok is false if containerName archives m itself.
+func (r *backupRun) sharedOwner(containerName string, m types.MountPoint) (sharedOwner, bool) {
+	owner, ok := r.owners[mountKey(m)]
+	if !ok || (owner.Container == containerName && owner.Mount.Destination == m.Destination) {
+		return sharedOwner{}, false
+	}
+	return owner, true
+}
+
+// archivedShared records the result of archiving m, if containerName is the owner of a shared volume/bind.
+func (r *backupRun) archivedShared(containerName string, m types.MountPoint, err error) {
+	res, ok := r.archived[mountKey(m)]
+	if owner := r.owners[mountKey(m)]; ok && owner.Container == containerName && owner.Mount.Destination == m.Destination {
+		res.finish(err)
+	}
+}
+
+// releaseOwned fails the shared volumes/binds of containerName, which it didn't archive, i.e. because its backup failed before.
+func (r *backupRun) releaseOwned(containerName string) {
+	for key, owner := range r.owners {
+		if res, ok := r.archived[key]; ok && owner.Container == containerName {
+			res.finish(errors.New("backup of " + containerName + " ended before it was archived"))
+		}
+	}
+}
+
+// waitShared waits until the owner of m archived it and returns its error.
+func (r *backupRun) waitShared(m types.MountPoint) error {
+	res, ok := r.archived[mountKey(m)]
+	if !ok {
+		return nil
+	}
+	r.waiting.Add(1)
+	defer r.waiting.Add(-1)
+	<-res.done
+	return res.err
+}
+
+// Waiting returns the number of containers waiting for a shared archive.
+func (r *backupRun) Waiting() int {
+	return int(r.waiting.Load())
+}
+
+// sharedWith returns the other containers, which mount m as well.
+func (r *backupRun) sharedWith(containerName string, m types.MountPoint) []string {
+	sharedWith := []string{}
+	for _, s := range r.sharers[mountKey(m)] {
+		if s != containerName {
+			sharedWith = append(sharedWith, s)
+		}
+	}
+	return sharedWith
+}
+
+// mountKey identifies the data behind a mount, independent of the container it is mounted in.
+func mountKey(m types.MountPoint) string {
+	if m.Type == mount.TypeVolume {
+		return "volume:" + m.Name
+	}
+	return string(m.Type) + ":" + m.Source
+}
@@ -0,0 +1,70 @@
+package docker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+)
+
+func TestBackupRunSharedResult(t *testing.T) {
+	data := types.MountPoint{Type: mount.TypeVolume, Name: "data", Destination: "/data"}
+	containers := []types.Container{
+		{Names: []string{"/app"}, Labels: map[string]string{LabelRetentionHours: "168"}, Mounts: []types.MountPoint{data}},
+		{Names: []string{"/worker"}, Labels: map[string]string{LabelRetentionHours: "24"}, Mounts: []types.MountPoint{data}},
+	}
+
+	tests := []struct {
+		name    string
+		finish  func(run *backupRun)
+		wantErr bool
+	}{
+		{"archived", func(run *backupRun) { run.archivedShared("app", data, nil) }, false},
+		{"archive failed", func(run *backupRun) { run.archivedShared("app", data, errors.New("tar failed")) }, true},
+		{"owner ended before archiving", func(run *backupRun) { run.releaseOwned("app") }, true},
+		{"release after archiving keeps the result", func(run *backupRun) {
+			run.archivedShared("app", data, nil)
+			run.releaseOwned("app")
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := newBackupRun(containers)
+			owner, ok := run.sharedOwner("worker", data)
+			if !ok || owner.Container != "app" {
+				t.Fatalf("sharedOwner() = %+v, %v, want owner app", owner, ok)
+			}
+			if _, ok := run.sharedOwner("app", data); ok {
+				t.Fatal("sharedOwner() of the owner itself returned ok")
+			}
+
+			result := make(chan error)
+			go func() { result <- run.waitShared(data) }()
+
+			// The sharer must not be released by the results of other containers
+			run.archivedShared("worker", data, errors.New("not the owner"))
+			run.releaseOwned("worker")
+			select {
+			case err := <-result:
+				t.Fatalf("waitShared() returned before the owner finished: %v", err)
+			case <-time.After(50 * time.Millisecond):
+			}
+			if run.Waiting() != 1 {
+				t.Errorf("Waiting() = %d, want 1", run.Waiting())
+			}
+
+			tt.finish(run)
+			select {
+			case err := <-result:
+				if (err != nil) != tt.wantErr {
+					t.Errorf("waitShared() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("waitShared() did not return after the owner finished")
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bata94/DockerRight/internal/config"
+
+	"github.com/docker/docker/api/types"
+)
+
+// ContainerStats is the resource usage of a container at a poll. CPU and I/O rates are 0 for the first poll,
+// they are calculated from the difference to the previous one.
+type ContainerStats struct {
+	Read          time.Time
+	CPUPercent    float64
+	MemoryUsage   uint64
+	MemoryLimit   uint64
+	MemoryPercent float64
+	// BlockIOBytes (read and written) and NetworkBytes (received and sent) are totals since the container started.
+	BlockIOBytes uint64
+	NetworkBytes uint64
+	BlockIOMBps  float64
+	NetworkMBps  float64
+
+	// rates is false for the first poll, there is nothing to calculate the rates from.
+	rates bool
+
+	cpuUsage    uint64
+	systemUsage uint64
+	onlineCPUs  uint32
+}
+
+// ThresholdState tracks a resource usage threshold, Since is the time it was first exceeded, zero if it is not.
+type ThresholdState struct {
+	Since    time.Time
+	Notified bool
+}
+
+// updateStats reads the current resource usage of a running container into ci.Stats.
+func updateStats(ci *ContainerInfo) error {
+	resp, err := cli.ContainerStatsOneShot(ctx, ci.ID)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var raw types.StatsJSON
+	err = json.NewDecoder(resp.Body).Decode(&raw)
+	if err != nil {
+		return err
+	}
+
+	stats := ContainerStats{
+		Read:        raw.Read,
+		MemoryUsage: raw.MemoryStats.Usage,
+		MemoryLimit: raw.MemoryStats.Limit,
+		cpuUsage:    raw.CPUStats.CPUUsage.TotalUsage,
+		systemUsage: raw.CPUStats.SystemUsage,
+		onlineCPUs:  raw.CPUStats.OnlineCPUs,
+	}
+	// Like docker stats, the page cache isn't counted as used memory (cgroup v1 and v2)
+	if cache, ok := raw.MemoryStats.Stats["total_inactive_file"]; ok && cache < stats.MemoryUsage {
+		stats.MemoryUsage -= cache
+	} else if cache, ok := raw.MemoryStats.Stats["inactive_file"]; ok && cache < stats.MemoryUsage {
+		stats.MemoryUsage -= cache
+	}
+	if stats.MemoryLimit > 0 {
+		stats.MemoryPercent = float64(stats.MemoryUsage) / float64(stats.MemoryLimit) * 100
+	}
+	for _, e := range raw.BlkioStats.IoServiceBytesRecursive {
+		if op := strings.ToLower(e.Op); op == "read" || op == "write" {
+			stats.BlockIOBytes += e.Value
+		}
+	}
+	for _, n := range raw.Networks {
+		stats.NetworkBytes += n.RxBytes + n.TxBytes
+	}
+
+	// Counters are reset if the container restarted in between
+	prev := ci.Stats
+	if prev != nil && stats.Read.After(prev.Read) && stats.cpuUsage >= prev.cpuUsage && stats.systemUsage > prev.systemUsage {
+		stats.rates = true
+		stats.CPUPercent = float64(stats.cpuUsage-prev.cpuUsage) / float64(stats.systemUsage-prev.systemUsage) * float64(stats.onlineCPUs) * 100
+
+		seconds := stats.Read.Sub(prev.Read).Seconds()
+		if stats.BlockIOBytes >= prev.BlockIOBytes {
+			stats.BlockIOMBps = float64(stats.BlockIOBytes-prev.BlockIOBytes) / 1024 / 1024 / seconds
+		}
+		if stats.NetworkBytes >= prev.NetworkBytes {
+			stats.NetworkMBps = float64(stats.NetworkBytes-prev.NetworkBytes) / 1024 / 1024 / seconds
+		}
+	}
+
+	ci.Stats = &stats
+	return nil
+}
+
+// checkThresholds notifies if a resource usage stays over its threshold for MonitorStatsWindowSeconds,
+// and again once it is back under it.
+func checkThresholds(ci *ContainerInfo, s ContainerSettings) {
+	if ci.Stats == nil {
+		return
+	}
+	if ci.Thresholds == nil {
+		ci.Thresholds = map[string]*ThresholdState{}
+	}
+
+	checkThreshold(ci, "Memory usage", s.MonitorMemoryPercent, ci.Stats.MemoryPercent, "% of the limit")
+	// Rates are only known from the second poll on
+	if !ci.Stats.rates {
+		return
+	}
+	checkThreshold(ci, "CPU usage", s.MonitorCPUPercent, ci.Stats.CPUPercent, "%")
+	checkThreshold(ci, "Block I/O", s.MonitorBlockIOMBps, ci.Stats.BlockIOMBps, " MB/s")
+	checkThreshold(ci, "Network I/O", s.MonitorNetworkMBps, ci.Stats.NetworkMBps, " MB/s")
+}
+
+func checkThreshold(ci *ContainerInfo, name string, limit int, value float64, unit string) {
+	if limit <= 0 {
+		delete(ci.Thresholds, name)
+		return
+	}
+	t, ok := ci.Thresholds[name]
+	if !ok {
+		t = &ThresholdState{}
+		ci.Thresholds[name] = t
+	}
+	window := time.Duration(config.Conf.MonitorStatsWindowSeconds) * time.Second
+
+	if value > float64(limit) {
+		if t.Since.IsZero() {
+			t.Since = ci.Stats.Read
+		}
+		if !t.Notified && ci.Stats.Read.Sub(t.Since) >= window {
+			t.Notified = true
+			ci.Alert(ci.Name, " ", name, " is over ", limit, unit, " for ", window, " (currently ", fmt.Sprintf("%.1f", value), unit, ")!")
+		}
+		return
+	}
+
+	t.Since = time.Time{}
+	if t.Notified {
+		t.Notified = false
+		ci.Alert(ci.Name, " ", name, " is back under ", limit, unit, " (currently ", fmt.Sprintf("%.1f", value), unit, ") :)")
+	}
+}
+
+// hasThresholds is true if any resource usage threshold is set, otherwise no stats are read.
+func (s ContainerSettings) hasThresholds() bool {
+	return s.MonitorCPUPercent > 0 || s.MonitorMemoryPercent > 0 || s.MonitorBlockIOMBps > 0 || s.MonitorNetworkMBps > 0
+}
@@ -0,0 +1,195 @@
+package docker
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bata94/DockerRight/internal/config"
+	"github.com/bata94/DockerRight/internal/log"
+	"github.com/bata94/DockerRight/internal/retention"
+	"github.com/bata94/DockerRight/internal/storage"
+)
+
+// target is a BackupTarget with its opened storage backend.
+type target struct {
+	Name  string
+	Store storage.Backend
+	// Retention overrides the retention of the containers, nil if the target has none.
+	Retention *retention.Policy
+}
+
+var (
+	// targets every snapshot is replicated to. Snapshots are always written into the BackupPath first,
+	// they are uploaded to every target, that is not the BackupPath itself, and removed locally afterwards.
+	targets []target
+	// store is the target restore, verify and snapshots read from, the first target unless selected with UseTarget.
+	store storage.Backend
+)
+
+func initStorage() error {
+	targets = []target{}
+	for _, t := range config.Conf.Targets() {
+		b, err := newBackend(t)
+		if err != nil {
+			return errors.New("Error initializing BackupTarget " + t.Name + ": " + err.Error())
+		}
+
+		tgt := target{Name: t.Name, Store: b}
+		if t.RetentionPolicy != "" {
+			p, err := retention.ParsePolicy(t.RetentionPolicy)
+			if err != nil {
+				return errors.New("Error parsing RetentionPolicy of BackupTarget " + t.Name + ": " + err.Error())
+			}
+			tgt.Retention = &p
+		}
+		targets = append(targets, tgt)
+		log.Info("Storing snapshots in ", t.Name, " (", b, ")")
+	}
+	store = targets[0].Store
+
+	return nil
+}
+
+func newBackend(t config.BackupTarget) (storage.Backend, error) {
+	switch t.Backend {
+	case "s3":
+		s3, err := storage.NewS3(*t.S3)
+		if err != nil {
+			return nil, err
+		}
+		return s3, nil
+	case "sftp":
+		sftp, err := storage.NewSFTP(*t.SFTP)
+		if err != nil {
+			return nil, err
+		}
+		return sftp, nil
+	default:
+		return storage.NewLocal(t.Path), nil
+	}
+}
+
+// UseTarget selects the target restore, verify and snapshots read from.
+func UseTarget(name string) error {
+	for _, t := range targets {
+		if t.Name == name {
+			store = t.Store
+			return nil
+		}
+	}
+	return errors.New("BackupTarget not found: " + name)
+}
+
+// isBackupPath is true if b stores snapshots in the BackupPath, where they are written to.
+func isBackupPath(b storage.Backend) bool {
+	l, ok := b.(*storage.Local)
+	return ok && l.Path == strings.TrimSuffix(config.Conf.BackupPath, "/")
+}
+
+// snapshotKey is the key prefix of all objects of a snapshot.
+func snapshotKey(containerName, snapshot string) string {
+	return strings.TrimPrefix(containerName, "/") + "/" + snapshot + "/"
+}
+
+// storeSnapshot replicates a snapshot written into the BackupPath to all targets and returns the error of every target.
+// The local copy is removed afterwards, unless a target is the BackupPath itself or an upload failed.
+// Targets already containing every file of the snapshot are skipped, so a kept snapshot can be stored again later.
+func storeSnapshot(containerName, snapshot string) map[string]error {
+	localPath := strings.TrimSuffix(config.Conf.BackupPath, "/") + "/" + snapshotKey(containerName, snapshot)
+	results := map[string]error{}
+	keepLocal := false
+
+	for _, t := range targets {
+		if isBackupPath(t.Store) {
+			keepLocal = true
+			results[t.Name] = nil
+			continue
+		}
+
+		if snapshotStored(t.Store, localPath, snapshotKey(containerName, snapshot)) {
+			results[t.Name] = nil
+			continue
+		}
+
+		log.Info("Uploading ", localPath, " to ", t.Name, " (", t.Store, ")")
+		err := storage.PutDir(t.Store, localPath, snapshotKey(containerName, snapshot))
+		if err != nil {
+			log.Error("Error uploading snapshot to ", t.Name, ", it is kept in ", localPath, ": ", err)
+			keepLocal = true
+		}
+		results[t.Name] = err
+	}
+
+	if !keepLocal {
+		err := os.RemoveAll(localPath)
+		if err != nil {
+			log.Error("Error removing uploaded snapshot ", localPath, ": ", err)
+		}
+	}
+	return results
+}
+
+// retryStoreSnapshots stores the snapshots kept in the BackupPath after a failed upload again, the results are recorded in run.
+// Nothing is retried if the BackupPath is a target itself, all snapshots are kept there anyway.
+func retryStoreSnapshots(run *backupRun) {
+	for _, t := range targets {
+		if isBackupPath(t.Store) {
+			return
+		}
+	}
+
+	backupPath := strings.TrimSuffix(config.Conf.BackupPath, "/")
+	containerDirs, err := os.ReadDir(backupPath)
+	if err != nil {
+		log.Error("Error reading backup path: ", err)
+		return
+	}
+	for _, c := range containerDirs {
+		if !c.IsDir() || strings.HasPrefix(c.Name(), ".") {
+			continue
+		}
+		snapshots, err := os.ReadDir(backupPath + "/" + c.Name())
+		if err != nil {
+			log.Error("Error reading backup path: ", err)
+			continue
+		}
+		for _, s := range snapshots {
+			if !s.IsDir() {
+				continue
+			}
+			log.Info("Snapshot ", c.Name(), "/", s.Name(), " was kept after a failed upload, storing it again")
+			run.recordStored(c.Name(), storeSnapshot(c.Name(), s.Name()))
+		}
+	}
+}
+
+// snapshotStored is true if b contains every file of the local snapshot with the same size.
+func snapshotStored(b storage.Backend, localPath, key string) bool {
+	stored := true
+	err := filepath.WalkDir(localPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !stored {
+			return err
+		}
+		rel, err := filepath.Rel(localPath, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size, err := b.Stat(key + filepath.ToSlash(rel))
+		stored = err == nil && size == info.Size()
+		return nil
+	})
+	return err == nil && stored
+}
+
+// snapshotExists is true if b contains any object of the snapshot.
+func snapshotExists(b storage.Backend, containerName, snapshot string) bool {
+	keys, err := b.List(snapshotKey(containerName, snapshot))
+	return err == nil && len(keys) != 0
+}
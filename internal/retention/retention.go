@@ -0,0 +1,142 @@
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is a grandfather-father-son retention policy.
+// All snapshots younger than Within hours are kept, additionally the newest snapshot of the last
+// Hourly hours, Daily days, Weekly weeks, Monthly months and Yearly years (that have a snapshot).
+type Policy struct {
+	Within  int
+	Hourly  int
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+}
+
+// Decision is the result of a policy for a single snapshot, Reasons is empty if it is pruned.
+type Decision struct {
+	Time    time.Time
+	Keep    bool
+	Reasons []string
+}
+
+// ParsePolicy parses a policy like "within=24,daily=7,weekly=4,monthly=12".
+func ParsePolicy(s string) (Policy, error) {
+	p := Policy{}
+	s = strings.ReplaceAll(s, " ", "")
+	if s == "" {
+		return p, errors.New("Empty retention policy")
+	}
+
+	for _, rule := range strings.Split(s, ",") {
+		key, val, ok := strings.Cut(rule, "=")
+		if !ok {
+			return p, errors.New("Invalid retention rule '" + rule + "', expected <rule>=<count>")
+		}
+		count, err := strconv.Atoi(val)
+		if err != nil || count < 0 {
+			return p, errors.New("Invalid count in retention rule '" + rule + "'")
+		}
+
+		switch strings.ToLower(key) {
+		case "within":
+			p.Within = count
+		case "hourly":
+			p.Hourly = count
+		case "daily":
+			p.Daily = count
+		case "weekly":
+			p.Weekly = count
+		case "monthly":
+			p.Monthly = count
+		case "yearly":
+			p.Yearly = count
+		default:
+			return p, errors.New("Unknown retention rule '" + key + "', valid rules are within, hourly, daily, weekly, monthly, yearly")
+		}
+	}
+
+	return p, nil
+}
+
+func (p Policy) String() string {
+	return fmt.Sprintf("within=%d,hourly=%d,daily=%d,weekly=%d,monthly=%d,yearly=%d", p.Within, p.Hourly, p.Daily, p.Weekly, p.Monthly, p.Yearly)
+}
+
+// MaxAge is the longest time a snapshot can be kept by the policy.
+func (p Policy) MaxAge() time.Duration {
+	maxAge := time.Duration(p.Within) * time.Hour
+	for _, age := range []time.Duration{
+		time.Duration(p.Hourly) * time.Hour,
+		time.Duration(p.Daily) * 24 * time.Hour,
+		time.Duration(p.Weekly) * 7 * 24 * time.Hour,
+		time.Duration(p.Monthly) * 31 * 24 * time.Hour,
+		time.Duration(p.Yearly) * 366 * 24 * time.Hour,
+	} {
+		if age > maxAge {
+			maxAge = age
+		}
+	}
+	return maxAge
+}
+
+// Apply evaluates the policy against the snapshot times, the decisions are sorted newest first.
+func (p Policy) Apply(snapshots []time.Time, now time.Time) []Decision {
+	decisions := make([]Decision, len(snapshots))
+	for i, t := range snapshots {
+		decisions[i] = Decision{Time: t}
+	}
+	sort.Slice(decisions, func(i, j int) bool { return decisions[i].Time.After(decisions[j].Time) })
+
+	for i, d := range decisions {
+		if now.Sub(d.Time) < time.Duration(p.Within)*time.Hour {
+			decisions[i].Reasons = append(decisions[i].Reasons, fmt.Sprintf("within %dh", p.Within))
+		}
+	}
+
+	buckets := []struct {
+		name  string
+		count int
+		key   func(t time.Time) string
+	}{
+		{"hourly", p.Hourly, func(t time.Time) string { return t.Format("2006-01-02-15") }},
+		{"daily", p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprint(year, "-", week)
+		}},
+		{"monthly", p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", p.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+	for _, b := range buckets {
+		// The newest snapshot of every period is kept, until count periods are covered
+		count := b.count
+		lastKey := ""
+		for i, d := range decisions {
+			if count == 0 {
+				break
+			}
+			key := b.key(d.Time)
+			if key == lastKey {
+				continue
+			}
+			lastKey = key
+			count--
+			decisions[i].Reasons = append(decisions[i].Reasons, b.name+" "+key)
+		}
+	}
+
+	for i, d := range decisions {
+		decisions[i].Keep = len(d.Reasons) != 0
+	}
+
+	return decisions
+}
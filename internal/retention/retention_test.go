@@ -0,0 +1,133 @@
+package retention
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    Policy
+		wantErr bool
+	}{
+		{"all rules", "within=24,hourly=6,daily=7,weekly=4,monthly=12,yearly=2", Policy{Within: 24, Hourly: 6, Daily: 7, Weekly: 4, Monthly: 12, Yearly: 2}, false},
+		{"spaces and case", " Daily = 7 , WEEKLY=4", Policy{Daily: 7, Weekly: 4}, false},
+		{"zero count", "daily=0", Policy{}, false},
+		{"empty", "", Policy{}, true},
+		{"only spaces", "   ", Policy{}, true},
+		{"missing count", "daily", Policy{}, true},
+		{"negative count", "daily=-1", Policy{}, true},
+		{"invalid count", "daily=seven", Policy{}, true},
+		{"unknown rule", "minutely=5", Policy{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePolicy(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePolicy(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParsePolicy(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyMaxAge(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		want   time.Duration
+	}{
+		{"empty", Policy{}, 0},
+		{"within", Policy{Within: 48}, 48 * time.Hour},
+		{"daily beats within", Policy{Within: 24, Daily: 7}, 7 * 24 * time.Hour},
+		{"yearly", Policy{Daily: 7, Yearly: 1}, 366 * 24 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.MaxAge(); got != tt.want {
+				t.Errorf("MaxAge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyApply(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	hoursAgo := func(h int) time.Time { return now.Add(-time.Duration(h) * time.Hour) }
+
+	tests := []struct {
+		name      string
+		policy    Policy
+		snapshots []time.Time
+		// kept are the snapshots expected to be kept
+		kept []time.Time
+	}{
+		{
+			name:      "nothing kept",
+			policy:    Policy{},
+			snapshots: []time.Time{hoursAgo(1), hoursAgo(30)},
+			kept:      nil,
+		},
+		{
+			name:      "within",
+			policy:    Policy{Within: 24},
+			snapshots: []time.Time{hoursAgo(1), hoursAgo(23), hoursAgo(25)},
+			kept:      []time.Time{hoursAgo(1), hoursAgo(23)},
+		},
+		{
+			name:      "newest per hour",
+			policy:    Policy{Hourly: 2},
+			snapshots: []time.Time{now.Add(-10 * time.Minute), now.Add(-50 * time.Minute), hoursAgo(2), hoursAgo(3)},
+			kept:      []time.Time{now.Add(-10 * time.Minute), hoursAgo(2)},
+		},
+		{
+			name:      "newest per day",
+			policy:    Policy{Daily: 2},
+			snapshots: []time.Time{hoursAgo(1), hoursAgo(2), hoursAgo(24), hoursAgo(26), hoursAgo(48)},
+			kept:      []time.Time{hoursAgo(1), hoursAgo(24)},
+		},
+		{
+			name:      "days without snapshots are not counted",
+			policy:    Policy{Daily: 2},
+			snapshots: []time.Time{hoursAgo(1), hoursAgo(24 * 10), hoursAgo(24 * 20)},
+			kept:      []time.Time{hoursAgo(1), hoursAgo(24 * 10)},
+		},
+		{
+			name:      "combined rules",
+			policy:    Policy{Within: 2, Monthly: 2},
+			snapshots: []time.Time{hoursAgo(1), hoursAgo(5), hoursAgo(24 * 20), hoursAgo(24 * 40)},
+			kept:      []time.Time{hoursAgo(1), hoursAgo(24 * 20)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions := tt.policy.Apply(tt.snapshots, now)
+			if len(decisions) != len(tt.snapshots) {
+				t.Fatalf("Apply() returned %d decisions, want %d", len(decisions), len(tt.snapshots))
+			}
+
+			kept := map[time.Time]bool{}
+			for _, k := range tt.kept {
+				kept[k] = true
+			}
+			for i, d := range decisions {
+				if i > 0 && d.Time.After(decisions[i-1].Time) {
+					t.Errorf("decisions are not sorted newest first: %v after %v", d.Time, decisions[i-1].Time)
+				}
+				if d.Keep != kept[d.Time] {
+					t.Errorf("snapshot %v: Keep = %v, want %v (reasons %v)", d.Time, d.Keep, kept[d.Time], d.Reasons)
+				}
+				if d.Keep != (len(d.Reasons) != 0) {
+					t.Errorf("snapshot %v: Keep = %v with reasons %v", d.Time, d.Keep, d.Reasons)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Local stores snapshots in a directory, i.e. the BackupPath.
+type Local struct {
+	Path string
+}
+
+func NewLocal(path string) *Local {
+	return &Local{Path: strings.TrimSuffix(path, "/")}
+}
+
+func (l *Local) String() string {
+	return "local:" + l.Path
+}
+
+func (l *Local) path(key string) string {
+	return l.Path + "/" + key
+}
+
+func (l *Local) Put(key string, r io.Reader, size int64) error {
+	path := l.path(key)
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	// Written to a temp file first, so a failed upload never replaces an existing object
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (l *Local) Get(key string) (io.ReadCloser, error) {
+	return os.Open(l.path(key))
+}
+
+func (l *Local) Stat(key string) (int64, error) {
+	info, err := os.Stat(l.path(key))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (l *Local) List(prefix string) ([]string, error) {
+	keys := []string{}
+	root := l.Path
+	// Only walk the directory of the prefix
+	if i := strings.LastIndex(prefix, "/"); i != -1 {
+		root = l.path(prefix[:i])
+	}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.Path, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		// Temp files of running or interrupted uploads are not objects
+		if strings.HasPrefix(key, prefix) && !strings.HasPrefix(d.Name(), ".upload-") {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Delete removes key and all directories left empty by it.
+func (l *Local) Delete(key string) error {
+	err := os.Remove(l.path(key))
+	if err != nil {
+		return err
+	}
+
+	for dir := filepath.Dir(l.path(key)); dir != l.Path && strings.HasPrefix(dir, l.Path); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config configures an S3 compatible backend (AWS, MinIO, Backblaze B2, ...).
+type S3Config struct {
+	Endpoint  string
+	Bucket    string
+	Prefix    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	// PartSizeMB is the size of the parts of multipart uploads, larger archives need larger parts (max 10000 parts).
+	PartSizeMB int
+}
+
+// S3 stores snapshots as objects in a bucket, below an optional prefix.
+type S3 struct {
+	client   *minio.Client
+	bucket   string
+	prefix   string
+	partSize uint64
+}
+
+func NewS3(c S3Config) (*S3, error) {
+	if c.Endpoint == "" || c.Bucket == "" {
+		return nil, errors.New("S3 endpoint and bucket are required")
+	}
+
+	client, err := minio.New(c.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(c.AccessKey, c.SecretKey, ""),
+		Secure: c.UseSSL,
+		Region: c.Region,
+	})
+	if err != nil {
+		return nil, errors.New("Error creating S3 client: " + err.Error())
+	}
+
+	exists, err := client.BucketExists(context.Background(), c.Bucket)
+	if err != nil {
+		return nil, errors.New("Error accessing S3 bucket " + c.Bucket + ": " + err.Error())
+	}
+	if !exists {
+		return nil, errors.New("S3 bucket " + c.Bucket + " does not exist")
+	}
+
+	prefix := strings.Trim(c.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &S3{
+		client:   client,
+		bucket:   c.Bucket,
+		prefix:   prefix,
+		partSize: uint64(c.PartSizeMB) * 1024 * 1024,
+	}, nil
+}
+
+func (s *S3) String() string {
+	return "s3:" + s.bucket + "/" + s.prefix
+}
+
+// Put uploads r, objects larger than the part size are uploaded with a multipart upload.
+func (s *S3) Put(key string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(context.Background(), s.bucket, s.prefix+key, r, size, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+		PartSize:    s.partSize,
+	})
+	return err
+}
+
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(context.Background(), s.bucket, s.prefix+key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s.mapError(key, err)
+	}
+	// GetObject is lazy, Stat returns the error of a missing object
+	_, err = obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, s.mapError(key, err)
+	}
+	return obj, nil
+}
+
+func (s *S3) Stat(key string) (int64, error) {
+	info, err := s.client.StatObject(context.Background(), s.bucket, s.prefix+key, minio.StatObjectOptions{})
+	if err != nil {
+		return 0, s.mapError(key, err)
+	}
+	return info.Size, nil
+}
+
+func (s *S3) List(prefix string) ([]string, error) {
+	keys := []string{}
+	for obj := range s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.prefix + prefix,
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		keys = append(keys, strings.TrimPrefix(obj.Key, s.prefix))
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (s *S3) Delete(key string) error {
+	return s.client.RemoveObject(context.Background(), s.bucket, s.prefix+key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3) mapError(key string, err error) error {
+	if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+	return err
+}
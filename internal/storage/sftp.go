@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPConfig configures an SFTP backend, authenticated with a private key and verified against a known_hosts file.
+type SFTPConfig struct {
+	Host           string
+	Port           int
+	User           string
+	KeyFile        string
+	KnownHostsFile string
+	// Path is the remote directory the snapshots are stored in.
+	Path string
+}
+
+// SFTP stores snapshots in a directory on a remote host.
+// The connection is opened on demand and reopened if it was closed in the meantime.
+type SFTP struct {
+	config    SFTPConfig
+	sshConfig *ssh.ClientConfig
+	root      string
+
+	mu     sync.Mutex
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+func NewSFTP(c SFTPConfig) (*SFTP, error) {
+	if c.Host == "" || c.User == "" || c.Path == "" {
+		return nil, errors.New("SFTP host, user and path are required")
+	}
+	if c.Port == 0 {
+		c.Port = 22
+	}
+
+	key, err := os.ReadFile(c.KeyFile)
+	if err != nil {
+		return nil, errors.New("Error reading SFTP key file: " + err.Error())
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, errors.New("Error parsing SFTP key file: " + err.Error())
+	}
+	hostKeyCallback, err := knownhosts.New(c.KnownHostsFile)
+	if err != nil {
+		return nil, errors.New("Error reading SFTP known_hosts file: " + err.Error())
+	}
+
+	s := &SFTP{
+		config: c,
+		sshConfig: &ssh.ClientConfig{
+			User:            c.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		},
+		root: strings.TrimSuffix(c.Path, "/"),
+	}
+
+	// Fail early on wrong credentials or host keys
+	client, err := s.sftp()
+	if err != nil {
+		return nil, err
+	}
+	err = client.MkdirAll(s.root)
+	if err != nil {
+		return nil, errors.New("Error creating SFTP path " + s.root + ": " + err.Error())
+	}
+
+	return s, nil
+}
+
+func (s *SFTP) String() string {
+	return "sftp:" + s.config.User + "@" + s.config.Host + ":" + s.root
+}
+
+// sftp returns the open client, (re)connecting if necessary.
+func (s *SFTP) sftp() (*sftp.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	addr := net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.Port))
+	conn, err := ssh.Dial("tcp", addr, s.sshConfig)
+	if err != nil {
+		return nil, errors.New("Error connecting to SFTP host " + addr + ": " + err.Error())
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, errors.New("Error starting SFTP session: " + err.Error())
+	}
+
+	s.conn = conn
+	s.client = client
+	go func() {
+		_ = conn.Wait()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.conn == conn {
+			s.conn = nil
+			s.client = nil
+		}
+	}()
+
+	return client, nil
+}
+
+func (s *SFTP) path(key string) string {
+	return s.root + "/" + key
+}
+
+func (s *SFTP) Put(key string, r io.Reader, size int64) error {
+	client, err := s.sftp()
+	if err != nil {
+		return err
+	}
+
+	p := s.path(key)
+	err = client.MkdirAll(path.Dir(p))
+	if err != nil {
+		return err
+	}
+
+	// Uploaded to a temp file first, so a failed upload never replaces an existing object
+	tmp := path.Dir(p) + "/.upload-" + path.Base(p)
+	f, err := client.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.ReadFrom(r)
+	if err != nil {
+		f.Close()
+		_ = client.Remove(tmp)
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		_ = client.Remove(tmp)
+		return err
+	}
+
+	return client.PosixRename(tmp, p)
+}
+
+func (s *SFTP) Get(key string) (io.ReadCloser, error) {
+	client, err := s.sftp()
+	if err != nil {
+		return nil, err
+	}
+	return client.Open(s.path(key))
+}
+
+func (s *SFTP) Stat(key string) (int64, error) {
+	client, err := s.sftp()
+	if err != nil {
+		return 0, err
+	}
+	info, err := client.Stat(s.path(key))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s *SFTP) List(prefix string) ([]string, error) {
+	client, err := s.sftp()
+	if err != nil {
+		return nil, err
+	}
+
+	root := s.root
+	// Only walk the directory of the prefix
+	if i := strings.LastIndex(prefix, "/"); i != -1 {
+		root = s.path(prefix[:i])
+	}
+
+	keys := []string{}
+	walker := client.Walk(root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if walker.Stat().IsDir() {
+			continue
+		}
+		key := strings.TrimPrefix(walker.Path(), s.root+"/")
+		if strings.HasPrefix(key, prefix) && !strings.HasPrefix(path.Base(key), ".upload-") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Delete removes key and all directories left empty by it.
+func (s *SFTP) Delete(key string) error {
+	client, err := s.sftp()
+	if err != nil {
+		return err
+	}
+
+	err = client.Remove(s.path(key))
+	if err != nil {
+		return err
+	}
+
+	for dir := path.Dir(s.path(key)); dir != s.root && strings.HasPrefix(dir, s.root); dir = path.Dir(dir) {
+		if client.RemoveDirectory(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Backend stores snapshot objects. Keys are slash separated paths relative to the root of the backend,
+// i.e. "<container>/<snapshot>/<file>".
+type Backend interface {
+	String() string
+	// Put stores the content of r under key, size is -1 if unknown.
+	Put(key string, r io.Reader, size int64) error
+	// Get returns the content of key, the error wraps fs.ErrNotExist if it doesn't exist.
+	Get(key string) (io.ReadCloser, error)
+	// Stat returns the size of key, the error wraps fs.ErrNotExist if it doesn't exist.
+	Stat(key string) (int64, error)
+	// List returns all keys below prefix (recursive), sorted.
+	List(prefix string) ([]string, error)
+	Delete(key string) error
+}
+
+func IsNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// ListDirs returns the first path elements below prefix, i.e. the snapshots for prefix "<container>/".
+func ListDirs(b Backend, prefix string) ([]string, error) {
+	keys, err := b.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := []string{}
+	for _, k := range keys {
+		dir, _, ok := strings.Cut(strings.TrimPrefix(k, prefix), "/")
+		if ok && (len(dirs) == 0 || dirs[len(dirs)-1] != dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+
+	return dirs, nil
+}
+
+// DeletePrefix deletes all keys below prefix, i.e. a whole snapshot.
+func DeletePrefix(b Backend, prefix string) error {
+	keys, err := b.List(prefix)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		err := b.Delete(k)
+		if err != nil {
+			return errors.New("Error deleting " + k + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// PutDir uploads all files of a local directory below prefix.
+func PutDir(b Backend, dir, prefix string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		err = b.Put(prefix+filepath.ToSlash(rel), f, info.Size())
+		if err != nil {
+			return errors.New("Error uploading " + rel + " to " + b.String() + ": " + err.Error())
+		}
+		return nil
+	})
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestLocal returns a Local backend in a temp dir containing keys.
+func newTestLocal(t *testing.T, keys ...string) *Local {
+	t.Helper()
+	l := NewLocal(t.TempDir())
+	for _, k := range keys {
+		if err := l.Put(k, strings.NewReader(k), int64(len(k))); err != nil {
+			t.Fatalf("Put(%q) error = %v", k, err)
+		}
+	}
+	return l
+}
+
+func TestListDirs(t *testing.T) {
+	l := newTestLocal(t,
+		"app/2024-03-15-03-00-00/manifest.json",
+		"app/2024-03-15-03-00-00/data.tar.gz",
+		"app/2024-03-14-03-00-00/manifest.json",
+		"app/2024-03-14-03-00-00/logs/data.log",
+		"app/stray-file",
+		"app-worker/2024-03-15-03-00-00/manifest.json",
+		"db/2024-03-15-03-00-00/dump.sql",
+	)
+
+	tests := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{"containers", "", []string{"app", "app-worker", "db"}},
+		{"snapshots", "app/", []string{"2024-03-14-03-00-00", "2024-03-15-03-00-00"}},
+		{"no prefix match of other containers", "app-worker/", []string{"2024-03-15-03-00-00"}},
+		{"files of a snapshot", "app/2024-03-14-03-00-00/", []string{"logs"}},
+		{"unknown container", "missing/", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ListDirs(l, tt.prefix)
+			if err != nil {
+				t.Fatalf("ListDirs(%q) error = %v", tt.prefix, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListDirs(%q) = %v, want %v", tt.prefix, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalList(t *testing.T) {
+	l := newTestLocal(t,
+		"app/2024-03-15-03-00-00/manifest.json",
+		"app/2024-03-15-03-00-00/data.tar.gz",
+		"app-worker/2024-03-15-03-00-00/manifest.json",
+	)
+	// Temp file of an interrupted upload
+	err := os.WriteFile(filepath.Join(l.Path, "app/2024-03-15-03-00-00/.upload-123"), []byte("partial"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{"all", "", []string{"app-worker/2024-03-15-03-00-00/manifest.json", "app/2024-03-15-03-00-00/data.tar.gz", "app/2024-03-15-03-00-00/manifest.json"}},
+		{"snapshot", "app/2024-03-15-03-00-00/", []string{"app/2024-03-15-03-00-00/data.tar.gz", "app/2024-03-15-03-00-00/manifest.json"}},
+		{"partial name", "app/2024-03-15-03-00-00/data", []string{"app/2024-03-15-03-00-00/data.tar.gz"}},
+		{"missing", "missing/", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := l.List(tt.prefix)
+			if err != nil {
+				t.Fatalf("List(%q) error = %v", tt.prefix, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("List(%q) = %v, want %v", tt.prefix, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	l := newTestLocal(t,
+		"app/2024-03-14-03-00-00/manifest.json",
+		"app/2024-03-15-03-00-00/manifest.json",
+		"app/2024-03-15-03-00-00/data.tar.gz",
+	)
+
+	if err := DeletePrefix(l, "app/2024-03-15-03-00-00/"); err != nil {
+		t.Fatalf("DeletePrefix() error = %v", err)
+	}
+
+	got, err := ListDirs(l, "app/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"2024-03-14-03-00-00"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListDirs() after DeletePrefix = %v, want %v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(l.Path, "app/2024-03-15-03-00-00")); !os.IsNotExist(err) {
+		t.Errorf("snapshot directory was not removed: %v", err)
+	}
+}